@@ -0,0 +1,26 @@
+package morondanga
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// backoffDelay returns the delay before the given retry attempt (starting at 0),
+// using exponential backoff capped at max (no cap when max <= 0), with full
+// jitter so instances retrying at the same time spread out.
+func backoffDelay(attempt int, initial, max time.Duration) time.Duration {
+	if initial <= 0 {
+		return 0
+	}
+	d := initial
+	for i := 0; i < attempt && (max <= 0 || d < max) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	// keep at least half of the delay, so retries never become a busy loop
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
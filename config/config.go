@@ -1,65 +1,108 @@
 package config
 
 import (
-	"fmt"
-	"net/url"
-	"path/filepath"
 	"strings"
 	"time"
-
-	"github.com/spf13/viper"
 )
 
 var (
 	DefaultAppName          = "MyApp"
 	defaultHttpAddress      = ":8080"
 	DefaultJwtExpiration    = time.Hour * 48
-	DefaultJwtSigningKey    = "default-signing-key"
 	DefaultHttpReadTimeout  = time.Second * 5
 	DefaultHttpWriteTimeout = time.Second * 10
 	DefaultHttpIdleTimeout  = time.Minute * 2
+	// DefaultHttpReadHeaderTimeout protects against slow clients sending headers.
+	DefaultHttpReadHeaderTimeout = time.Second * 2
+	DefaultHttpMaxHeaderBytes    = 1 << 20
+	// DefaultHttpShutdownTimeout is the time given to in-flight requests to
+	// complete once a graceful shutdown has started.
+	DefaultHttpShutdownTimeout = time.Second * 30
+	DefaultHealthCacheTTL      = time.Second * 5
+	DefaultHealthTimeout       = time.Second * 2
+	DefaultTLSMinVersion       = "1.2"
+	DefaultTLSReloadInterval   = time.Second * 10
+
+	defaultAdminAddress      = ":9090"
+	DefaultAdminReadTimeout  = time.Second * 5
+	DefaultAdminWriteTimeout = time.Minute
+	DefaultAdminIdleTimeout  = time.Minute * 2
+
+	DefaultConnectAttempts       = 1
+	DefaultConnectInitialBackoff = time.Millisecond * 500
+	DefaultConnectMaxBackoff     = time.Second * 10
+	DefaultConnectTimeout        = time.Minute
+
+	DefaultDatabaseMaxOpenConns    = 25
+	DefaultDatabaseMaxIdleConns    = 10
+	DefaultDatabaseConnMaxLifetime = time.Minute * 30
+	DefaultDatabaseConnMaxIdleTime = time.Minute * 5
+	DefaultDatabaseSlowThreshold   = time.Second
+	// DefaultDatabaseReplicaHealthInterval is the time between health checks
+	// of the read replicas.
+	DefaultDatabaseReplicaHealthInterval = time.Second * 10
 )
 
+// DefaultJwtSigningKey is not used: the JWT signing key has no default, and
+// Validate requires one when JWT is enabled.
+//
+// Deprecated: set HttpConfig.JwtSigningKey.
+var DefaultJwtSigningKey = "default-signing-key"
+
 type (
 	// ConfigTemplate defines the interface for custom Configuration structures.
 	ConfigTemplate interface {
 		GetApp() *AppConfig
 		GetHTTP() *HttpConfig
 		GetDatabase() *DatabaseConfig
+		GetDatabases() map[string]*DatabaseConfig
 		GetRedis() *RedisConfig
 		GetObservability() *ObservabilityConfig
+		GetAdmin() *AdminConfig
 		GetCustomValue(name string) (interface{}, bool)
 		SetDefaults()
+		Validate() error
 	}
 
 	// Config contains the global service settings.
 	Config struct {
-		App             AppConfig
-		HTTP            HttpConfig
-		Database        DatabaseConfig
-		Redis           RedisConfig
-		Observability   ObservabilityConfig
-		Custom          map[string]interface{}
+		App      AppConfig
+		HTTP     HttpConfig
+		Database DatabaseConfig
+		// Databases are additional connections, by name, each with its own
+		// settings, e.g. to talk to a legacy database besides the main one.
+		Databases     map[string]*DatabaseConfig `validate:"dive"`
+		Redis         RedisConfig
+		Admin         AdminConfig
+		Observability ObservabilityConfig
+		Custom        map[string]interface{}
 	}
 
 	// AppConfig holds the application settings
 	AppConfig struct {
 		Name      string
-		LogLevel  int
-		LogFormat string
+		LogLevel  int    `validate:"min=-1,max=5"`
+		LogFormat string `validate:"oneof=json console"`
+		// WatchConfig reloads the configuration file when it changes. Only some
+		// settings apply live, like the log level, masked fields and excluded
+		// paths; the rest can be handled with Service.OnConfigChange.
+		WatchConfig bool
 	}
 
 	// HttpConfig holds the HTTP server related configuration
 	HttpConfig struct {
+		// Address is the TCP host:port to listen on, or a Unix socket path as
+		// unix:///path/to/socket. It's ignored when the process is started by
+		// systemd socket activation.
 		Address            string
-		ReadTimeout        time.Duration
-		WriteTimeout       time.Duration
-		IdleTimeout        time.Duration
+		ReadTimeout        time.Duration `validate:"min=0"`
+		WriteTimeout       time.Duration `validate:"min=0"`
+		IdleTimeout        time.Duration `validate:"min=0"`
 		CustomHealthCheck  bool
-		AddTraceID         bool
+		AddTraceID         bool `mapstructure:"addTraceId"`
 		JwtEnabled         bool
-		JwtSigningKey      string
-		JwtTokenExpiration time.Duration
+		JwtSigningKey      string        `redact:"true"`
+		JwtTokenExpiration time.Duration `validate:"min=0"`
 		// MaskedBodyFields lists JSON field names (case-insensitive) whose values
 		// are replaced with "[REDACTED]" in request and response body logs.
 		// Applied recursively to nested objects and arrays.
@@ -69,6 +112,58 @@ type (
 		// redact in request and response logs. Authorization and X-Api-Key are
 		// always redacted regardless of this list.
 		MaskedHeaders []string
+		// ShutdownDelay is how long the service keeps serving after it has been
+		// marked as not ready, before draining starts. It gives load balancers
+		// and Kubernetes endpoints time to stop routing new traffic.
+		ShutdownDelay time.Duration `validate:"min=0"`
+		// ShutdownTimeout is the maximum time given to in-flight requests to
+		// complete during a graceful shutdown.
+		ShutdownTimeout time.Duration `validate:"min=0"`
+		// HealthCacheTTL is how long health check results are reused before
+		// the dependencies are checked again.
+		HealthCacheTTL time.Duration `validate:"min=0"`
+		// HealthTimeout bounds the duration of every health check.
+		HealthTimeout time.Duration `validate:"min=0"`
+		// TLS enables HTTPS, and optionally mutual TLS.
+		TLS TLSConfig
+		// SocketMode is the file mode of Unix sockets, in octal, e.g. "0660".
+		SocketMode string `validate:"omitempty,filemode"`
+		// ReusePort sets SO_REUSEPORT on TCP listeners, so several processes can
+		// share the same port, e.g. during zero-downtime binary upgrades.
+		ReusePort bool
+		// ReadHeaderTimeout is the maximum duration for reading the request headers.
+		ReadHeaderTimeout time.Duration `validate:"min=0"`
+		// MaxHeaderBytes is the maximum size of the request headers.
+		MaxHeaderBytes int `validate:"min=0"`
+		// DisableKeepAlives closes the connection after every request.
+		DisableKeepAlives bool
+		// H2C enables cleartext HTTP/2 (with prior knowledge) alongside HTTP/1.1,
+		// for internal traffic behind a mesh or load balancer that doesn't use TLS.
+		H2C bool
+	}
+
+	// TLSConfig holds the settings to serve HTTPS. Certificates are reloaded
+	// from disk when they change, without restarting the service.
+	TLSConfig struct {
+		Enabled  bool
+		CertFile string
+		KeyFile  string
+		// MinVersion is the minimum TLS version accepted: 1.0, 1.1, 1.2 or 1.3.
+		// Defaults to 1.2.
+		MinVersion string `validate:"omitempty,oneof=1.0 1.1 1.2 1.3"`
+		// CipherSuites restricts the cipher suites accepted up to TLS 1.2, by their
+		// standard names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. TLS 1.3 suites
+		// are not configurable.
+		CipherSuites []string
+		// ClientCAFile enables mutual TLS, verifying client certificates
+		// against the CA bundle in this file.
+		ClientCAFile string
+		// ClientAuth is the client certificate verification mode when ClientCAFile
+		// is set: "request" verifies it only if given, and "require" (default)
+		// rejects clients without a valid certificate.
+		ClientAuth string `validate:"omitempty,oneof=request require"`
+		// ReloadInterval is how often the files are checked for changes.
+		ReloadInterval time.Duration `validate:"min=0"`
 	}
 
 	// DatabaseConfig stores the database configuration
@@ -78,15 +173,94 @@ type (
 		Address  string
 		Port     uint16
 		User     string
-		Password string
+		Password string `redact:"true"`
 		Database string
+		Connect  ConnectConfig
+		// DSN is a full connection string, used as is instead of building one
+		// from the rest of the settings.
+		DSN string `redact:"true"`
+		// SSLMode is the TLS mode: disable (default), allow, prefer, require,
+		// verify-ca or verify-full. For mysql, allow and prefer fall back to
+		// plain text when the server doesn't support TLS.
+		SSLMode string `validate:"omitempty,oneof=disable allow prefer preferred require verify-ca verify-full"`
+		// SSLRootCert is the CA bundle used to verify the server certificate.
+		SSLRootCert string
+		// SSLCert and SSLKey are the client certificate and key.
+		SSLCert string
+		SSLKey  string
+		// Timezone is the session time zone, e.g. UTC or Europe/Madrid. For
+		// mysql, it's the location of the parsed time values.
+		Timezone string `validate:"omitempty,timezone"`
+		// Schema sets the search_path (postgres only).
+		Schema string
+		// ConnectTimeout bounds the time to establish a connection.
+		ConnectTimeout time.Duration `validate:"min=0"`
+		// ApplicationName identifies the service in the database server, e.g.
+		// in pg_stat_activity or performance_schema.session_connect_attrs.
+		ApplicationName string
+		// Params are additional driver parameters, which take precedence over
+		// the ones built from the settings above.
+		Params map[string]string
+		// MaxOpenConns and MaxIdleConns limit the connections of the pool. A
+		// negative value means no limit of open connections, or no idle
+		// connections kept at all.
+		MaxOpenConns int
+		MaxIdleConns int
+		// ConnMaxLifetime and ConnMaxIdleTime close the connections that have
+		// been open or idle for longer. A negative value means no limit.
+		ConnMaxLifetime time.Duration
+		ConnMaxIdleTime time.Duration
+		// LogLevel limits the queries logged: silent, error, warn (errors and
+		// slow queries) or info (every query, at debug level). When empty,
+		// it's left to app.logLevel.
+		LogLevel string `validate:"omitempty,oneof=silent error warn info"`
+		// SlowThreshold is the duration above which queries are logged as
+		// slow. A negative value disables it.
+		SlowThreshold time.Duration
+		// LogParams logs the query parameters instead of placeholders. They
+		// may contain personal data or secrets.
+		LogParams bool
+		// DisableTracing turns off the OpenTelemetry tracing of the queries
+		// of this connection, which are traced when observability is enabled.
+		DisableTracing bool
+		// AutoMigrate applies the pending migrations, given with
+		// morondanga.WithMigrations, once connected.
+		AutoMigrate bool
+		// Replicas are the addresses of the read replicas, which share the
+		// rest of the settings with the primary. When DSN is set, they are
+		// full connection strings instead, which are redacted like DSN.
+		Replicas []string `redact:"dsn"`
+		// ReplicaPolicy selects the replica of each read: random (default) or
+		// round-robin.
+		ReplicaPolicy string `validate:"omitempty,oneof=random round-robin"`
+		// ReplicaHealthInterval is the time between health checks of the
+		// replicas; the unhealthy ones get no reads until they recover.
+		ReplicaHealthInterval time.Duration `validate:"min=0"`
 	}
 
 	RedisConfig struct {
 		Enabled  bool
 		Address  string
-		Password string
+		Password string `redact:"true"`
 		Database int
+		Connect  ConnectConfig
+	}
+
+	// ConnectConfig controls how a dependency is connected on startup.
+	ConnectConfig struct {
+		// Attempts is the maximum number of connection attempts.
+		Attempts int `validate:"min=1"`
+		// InitialBackoff is the delay before the first retry. It doubles on
+		// every attempt, with jitter, up to MaxBackoff.
+		InitialBackoff time.Duration `validate:"min=0"`
+		MaxBackoff     time.Duration `validate:"min=0"`
+		// Timeout is the overall deadline to get connected, including retries.
+		Timeout time.Duration `validate:"min=0"`
+		// Lazy makes the service start without waiting for the connection,
+		// which is established in the background, retrying until Shutdown
+		// once the attempts run out. Meanwhile, the service is reported as
+		// not ready and the client accessor returns nil.
+		Lazy bool
 	}
 
 	// ObservabilityConfig holds OpenTelemetry settings.
@@ -95,14 +269,31 @@ type (
 		Enabled bool
 		// Endpoint is the OTLP HTTP base URL (e.g. http://localhost:4318).
 		// Defaults to http://localhost:4318 when empty.
-		Endpoint string
+		Endpoint string `validate:"omitempty,url"`
 		// ApiKey is sent as X-API-Key on every OTLP export request.
 		// Required when the collector enforces API key authentication.
-		ApiKey string
+		ApiKey string `redact:"true"`
 		// ExcludedPaths lists URL paths that should be excluded from tracing
 		// and metrics (e.g. ["/health", "/metrics"]). Exact prefix match.
 		ExcludedPaths []string
 	}
+
+	// AdminConfig holds the settings of the optional admin HTTP listener, which
+	// serves health, log level, routes and pprof endpoints apart from the public
+	// address, without request logging nor JWT.
+	AdminConfig struct {
+		Enabled     bool
+		Address     string
+		ReadTimeout time.Duration `validate:"min=0"`
+		// WriteTimeout must be longer than the profiling duration when using pprof.
+		WriteTimeout time.Duration `validate:"min=0"`
+		IdleTimeout  time.Duration `validate:"min=0"`
+		// Pprof enables the net/http/pprof handlers under /debug/pprof.
+		Pprof bool
+		// ConfigDump serves the effective configuration under /config, with
+		// secrets redacted.
+		ConfigDump bool
+	}
 )
 
 func (cfg *Config) GetApp() *AppConfig {
@@ -117,6 +308,10 @@ func (cfg *Config) GetDatabase() *DatabaseConfig {
 	return &cfg.Database
 }
 
+func (cfg *Config) GetDatabases() map[string]*DatabaseConfig {
+	return cfg.Databases
+}
+
 func (cfg *Config) GetRedis() *RedisConfig {
 	return &cfg.Redis
 }
@@ -125,49 +320,84 @@ func (cfg *Config) GetObservability() *ObservabilityConfig {
 	return &cfg.Observability
 }
 
+func (cfg *Config) GetAdmin() *AdminConfig {
+	return &cfg.Admin
+}
+
 func (cfg *Config) GetCustom() map[string]interface{} {
 	return cfg.Custom
 }
 
 // GetCustomValue returns the value of a custom configuration, if it's
 // present. The return type will depend of how the value is stored in the yaml.
+// The name is case-insensitive, as keys are lowercased by the loader but
+// not in configurations built in code.
 func (cfg *Config) GetCustomValue(name string) (interface{}, bool) {
-	val, ok := cfg.Custom[name]
-	return val, ok
+	return lookupKey(cfg.Custom, name)
 }
 
-// ConnectionString returns the connection string based on the configured driver.
+// ConnectionString returns the connection string based on the configured driver,
+// or DSN when it's set. Values are escaped as required by each driver.
 // Currently supported drivers are:
 //   - mysql
+//   - postgres
+//   - sqlite, where Database is the path of the file, or :memory:
 func (dbCfg *DatabaseConfig) ConnectionString() string {
 	if dbCfg.Enabled {
+		if dbCfg.DSN != "" {
+			return dbCfg.DSN
+		}
 		switch strings.ToLower(dbCfg.Driver) {
-
 		case "mysql":
-			return fmt.Sprintf(
-				"%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True",
-				dbCfg.User,
-				dbCfg.Password,
-				dbCfg.Address,
-				dbCfg.Database,
-			)
+			return dbCfg.mysqlDSN()
 		case "postgres":
-			userInfo := url.UserPassword(dbCfg.User, dbCfg.Password)
-			u := url.URL{
-				Scheme: "postgres",
-				User:   userInfo,
-				Host:   dbCfg.Address,
-				Path:   dbCfg.Database,
-			}
-			q := url.Values{}
-			q.Set("sslmode", "disable")
-			u.RawQuery = q.Encode()
-			return u.String()
+			return dbCfg.postgresDSN()
+		case "sqlite":
+			return dbCfg.sqliteDSN()
 		}
 	}
 	return ""
 }
 
+// SetDefaults sets the default values of the connection settings.
+func (c *ConnectConfig) SetDefaults() {
+	if c.Attempts == 0 {
+		c.Attempts = DefaultConnectAttempts
+	}
+	if c.InitialBackoff == 0 {
+		c.InitialBackoff = DefaultConnectInitialBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = DefaultConnectMaxBackoff
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultConnectTimeout
+	}
+}
+
+// SetDefaults sets the default values of the database settings.
+func (dbCfg *DatabaseConfig) SetDefaults() {
+	dbCfg.Connect.SetDefaults()
+	if dbCfg.MaxOpenConns == 0 {
+		dbCfg.MaxOpenConns = DefaultDatabaseMaxOpenConns
+	}
+	if dbCfg.MaxIdleConns == 0 {
+		dbCfg.MaxIdleConns = DefaultDatabaseMaxIdleConns
+	}
+	if dbCfg.ConnMaxLifetime == 0 {
+		dbCfg.ConnMaxLifetime = DefaultDatabaseConnMaxLifetime
+	}
+	if dbCfg.ConnMaxIdleTime == 0 {
+		dbCfg.ConnMaxIdleTime = DefaultDatabaseConnMaxIdleTime
+	}
+	if dbCfg.SlowThreshold == 0 {
+		dbCfg.SlowThreshold = DefaultDatabaseSlowThreshold
+	}
+	if dbCfg.ReplicaHealthInterval == 0 {
+		dbCfg.ReplicaHealthInterval = DefaultDatabaseReplicaHealthInterval
+	}
+}
+
 // SetDefaults checks the configuration values and sets some default where needed.
 func (cfg *Config) SetDefaults() {
 	if app := cfg.GetApp(); app != nil {
@@ -183,13 +413,9 @@ func (cfg *Config) SetDefaults() {
 		if httpCfg.Address == "" {
 			httpCfg.Address = defaultHttpAddress
 		}
-		if httpCfg.JwtEnabled {
-			if httpCfg.JwtSigningKey == "" {
-				httpCfg.JwtSigningKey = DefaultJwtSigningKey // DON'T USE THIS ON PRODUCTION!
-			}
-			if httpCfg.JwtTokenExpiration == 0 {
-				httpCfg.JwtTokenExpiration = DefaultJwtExpiration
-			}
+		// the signing key has no default: Validate rejects JWT without a key
+		if httpCfg.JwtEnabled && httpCfg.JwtTokenExpiration == 0 {
+			httpCfg.JwtTokenExpiration = DefaultJwtExpiration
 		}
 		if httpCfg.ReadTimeout == 0 {
 			httpCfg.ReadTimeout = DefaultHttpReadTimeout
@@ -200,53 +426,59 @@ func (cfg *Config) SetDefaults() {
 		if httpCfg.IdleTimeout == 0 {
 			httpCfg.IdleTimeout = DefaultHttpIdleTimeout
 		}
+		if httpCfg.ReadHeaderTimeout == 0 {
+			httpCfg.ReadHeaderTimeout = DefaultHttpReadHeaderTimeout
+		}
+		if httpCfg.MaxHeaderBytes == 0 {
+			httpCfg.MaxHeaderBytes = DefaultHttpMaxHeaderBytes
+		}
+		if httpCfg.ShutdownTimeout == 0 {
+			httpCfg.ShutdownTimeout = DefaultHttpShutdownTimeout
+		}
+		if httpCfg.HealthCacheTTL == 0 {
+			httpCfg.HealthCacheTTL = DefaultHealthCacheTTL
+		}
+		if httpCfg.HealthTimeout == 0 {
+			httpCfg.HealthTimeout = DefaultHealthTimeout
+		}
+		if httpCfg.TLS.Enabled {
+			if httpCfg.TLS.MinVersion == "" {
+				httpCfg.TLS.MinVersion = DefaultTLSMinVersion
+			}
+			if httpCfg.TLS.ClientCAFile != "" && httpCfg.TLS.ClientAuth == "" {
+				httpCfg.TLS.ClientAuth = "require"
+			}
+			if httpCfg.TLS.ReloadInterval == 0 {
+				httpCfg.TLS.ReloadInterval = DefaultTLSReloadInterval
+			}
+		}
 	}
-}
 
-// GetConfiguration loads the service configuration.
-func GetConfiguration(configFilePath string, cfgTemplate ConfigTemplate) error {
-	// parse config file name
-	dir, fileName, fileExt := parseFileName(configFilePath)
-	if fileName != "" {
-		viper.SetConfigName(fileName)
-	} else {
-		viper.SetConfigName("config") // use 'config' as default
-	}
-	if fileExt != "" {
-		viper.SetConfigType(fileExt)
-	} else {
-		viper.SetConfigType("yaml") // use 'yaml' as default
+	if adminCfg := cfg.GetAdmin(); adminCfg != nil && adminCfg.Enabled {
+		if adminCfg.Address == "" {
+			adminCfg.Address = defaultAdminAddress
+		}
+		if adminCfg.ReadTimeout == 0 {
+			adminCfg.ReadTimeout = DefaultAdminReadTimeout
+		}
+		if adminCfg.WriteTimeout == 0 {
+			adminCfg.WriteTimeout = DefaultAdminWriteTimeout
+		}
+		if adminCfg.IdleTimeout == 0 {
+			adminCfg.IdleTimeout = DefaultAdminIdleTimeout
+		}
 	}
 
-	viper.AddConfigPath(dir)
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	// Register optional keys that may only be supplied via env var (no YAML
-	// entry). Viper's Unmarshal uses AllSettings(), which only iterates keys
-	// it already knows about — SetDefault makes a key visible so AutomaticEnv
-	// picks up the corresponding env var even when the YAML omits the field.
-	viper.SetDefault("config.observability.apikey", "")
-	viper.AutomaticEnv()
-
-	if err := viper.ReadInConfig(); err != nil {
-		return err
+	if dbCfg := cfg.GetDatabase(); dbCfg != nil {
+		dbCfg.SetDefaults()
 	}
-	if err := viper.Unmarshal(cfgTemplate); err != nil {
-		return err
+	for _, dbCfg := range cfg.GetDatabases() {
+		if dbCfg != nil {
+			dbCfg.SetDefaults()
+		}
 	}
 
-	return nil
-}
-
-func parseFileName(f string) (string, string, string) {
-	dir := filepath.Dir(f)
-	file := filepath.Base(f)
-	ext := filepath.Ext(file)
-
-	if ext != "" {
-		sepIndex := strings.LastIndex(file, ".")
-		fileName := file[0:sepIndex]
-		return dir, fileName, strings.Replace(ext, ".", "", 1)
+	if redisCfg := cfg.GetRedis(); redisCfg != nil {
+		redisCfg.Connect.SetDefaults()
 	}
-
-	return dir, file, ""
 }
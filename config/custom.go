@@ -0,0 +1,119 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// DecodeCustom decodes the value of the custom section at key into a T, and
+// checks its validation rules. Nested values are selected with dots, e.g.
+// "kafka.consumer". Keys are case-insensitive, as the loader lowercases them
+// while configurations built in code may not.
+//
+// Values are decoded like the rest of the configuration, so durations can be
+// written as "5s" and lists as comma separated strings:
+//
+//	type KafkaConfig struct {
+//		Brokers []string      `validate:"required"`
+//		Timeout time.Duration `validate:"min=0"`
+//	}
+//
+//	kafkaCfg, err := config.DecodeCustom[KafkaConfig](service.Configuration(), "kafka")
+func DecodeCustom[T any](cfg ConfigTemplate, key string) (T, error) {
+	var out T
+
+	val, err := customValue(cfg, key)
+	if err != nil {
+		return out, err
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           &out,
+	})
+	if err != nil {
+		return out, err
+	}
+	if err := decoder.Decode(val); err != nil {
+		return out, fmt.Errorf("decode custom.%s: %w", key, err)
+	}
+
+	if reflect.Indirect(reflect.ValueOf(out)).Kind() == reflect.Struct {
+		if err := Validate(out); err != nil {
+			return out, prefixValidationErrors(err, "custom."+strings.ToLower(key))
+		}
+	}
+	return out, nil
+}
+
+// MustCustom is like DecodeCustom, but panics on errors. It's meant for
+// values the service can't run without, read during startup.
+func MustCustom[T any](cfg ConfigTemplate, key string) T {
+	out, err := DecodeCustom[T](cfg, key)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// customValue walks the custom section down to key.
+func customValue(cfg ConfigTemplate, key string) (any, error) {
+	parts := strings.Split(key, ".")
+	val, ok := cfg.GetCustomValue(parts[0])
+	for _, part := range parts[1:] {
+		if !ok {
+			break
+		}
+		var m map[string]any
+		if m, ok = val.(map[string]any); ok {
+			val, ok = lookupKey(m, part)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("custom.%s not found", key)
+	}
+	return val, nil
+}
+
+// lookupKey returns the value of key in m, matched case-insensitively when
+// there's no exact match. Among several matches, the first key in sorted
+// order wins, so the result doesn't depend on the map order.
+func lookupKey(m map[string]any, key string) (any, bool) {
+	if val, ok := m[key]; ok {
+		return val, true
+	}
+	match := ""
+	found := false
+	for k := range m {
+		if strings.EqualFold(k, key) && (!found || k < match) {
+			match, found = k, true
+		}
+	}
+	if !found {
+		return nil, false
+	}
+	return m[match], true
+}
+
+// prefixValidationErrors makes the paths of validation errors relative to
+// the configuration root.
+func prefixValidationErrors(err error, prefix string) error {
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+	out := make(ValidationErrors, len(verrs))
+	for i, e := range verrs {
+		e.Path = joinKey(prefix, e.Path)
+		out[i] = e
+	}
+	return out
+}
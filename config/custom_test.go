@@ -0,0 +1,109 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testKafkaConfig struct {
+	Brokers  []string `validate:"required"`
+	Timeout  time.Duration
+	Retries  int `validate:"min=0"`
+	Consumer struct {
+		Group string `validate:"required"`
+	}
+}
+
+func loadCustomTestConfig(t *testing.T, content string) *Config {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yml")
+	writeTestFile(t, file, content)
+	cfg := &Config{}
+	require.NoError(t, NewLoader().Load(file, cfg))
+	return cfg
+}
+
+func TestDecodeCustom(t *testing.T) {
+	t.Parallel()
+
+	cfg := loadCustomTestConfig(t, `
+custom:
+  kafka:
+    brokers: ["broker-1:9092", "broker-2:9092"]
+    timeout: 5s
+    retries: "3"
+    consumer:
+      group: orders
+  topics: "orders,payments"
+`)
+
+	kafkaCfg, err := DecodeCustom[testKafkaConfig](cfg, "kafka")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"broker-1:9092", "broker-2:9092"}, kafkaCfg.Brokers)
+	assert.Equal(t, 5*time.Second, kafkaCfg.Timeout)
+	assert.Equal(t, 3, kafkaCfg.Retries)
+	assert.Equal(t, "orders", kafkaCfg.Consumer.Group)
+
+	group, err := DecodeCustom[string](cfg, "Kafka.Consumer.Group")
+	require.NoError(t, err)
+	assert.Equal(t, "orders", group)
+
+	assert.Equal(t, []string{"orders", "payments"}, MustCustom[[]string](cfg, "topics"))
+}
+
+func TestDecodeCustomInMemory(t *testing.T) {
+	t.Parallel()
+
+	// not loaded, so keys keep their case
+	cfg := &Config{Custom: map[string]any{
+		"Kafka": map[string]any{
+			"Brokers":  []string{"broker-1:9092"},
+			"Consumer": map[string]any{"Group": "orders"},
+		},
+	}}
+
+	kafkaCfg, err := DecodeCustom[testKafkaConfig](cfg, "Kafka")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"broker-1:9092"}, kafkaCfg.Brokers)
+	assert.Equal(t, "orders", kafkaCfg.Consumer.Group)
+
+	group, err := DecodeCustom[string](cfg, "kafka.consumer.group")
+	require.NoError(t, err)
+	assert.Equal(t, "orders", group)
+
+	val, ok := cfg.GetCustomValue("KAFKA")
+	assert.True(t, ok)
+	assert.NotNil(t, val)
+}
+
+func TestDecodeCustomErrors(t *testing.T) {
+	t.Parallel()
+
+	cfg := loadCustomTestConfig(t, `
+custom:
+  kafka:
+    retries: -1
+`)
+
+	_, err := DecodeCustom[testKafkaConfig](cfg, "kafka")
+	var verrs ValidationErrors
+	require.ErrorAs(t, err, &verrs)
+	paths := []string{}
+	for _, e := range verrs {
+		paths = append(paths, e.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		"custom.kafka.brokers",
+		"custom.kafka.retries",
+		"custom.kafka.consumer.group",
+	}, paths)
+
+	_, err = DecodeCustom[testKafkaConfig](cfg, "redis")
+	assert.EqualError(t, err, "custom.redis not found")
+
+	assert.Panics(t, func() { MustCustom[testKafkaConfig](cfg, "kafka.missing") })
+}
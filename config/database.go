@@ -0,0 +1,248 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlParams are the parameters known by the mysql driver, which are case
+// sensitive, while the configuration loader lowercases map keys.
+var mysqlParams = []string{
+	"allowAllFiles", "allowCleartextPasswords", "allowFallbackToPlaintext",
+	"allowNativePasswords", "allowOldPasswords", "charset", "checkConnLiveness",
+	"clientFoundRows", "collation", "columnsWithAlias", "compress",
+	"connectionAttributes", "interpolateParams", "loc", "maxAllowedPacket",
+	"multiStatements", "parseTime", "readTimeout", "rejectReadOnly",
+	"serverPubKey", "timeout", "timeTruncate", "tls", "writeTimeout",
+}
+
+// Replica returns the settings of the replica at address, one of Replicas,
+// which are the same as the primary ones but for the address, or the DSN
+// when it's set.
+func (dbCfg *DatabaseConfig) Replica(address string) *DatabaseConfig {
+	replica := *dbCfg
+	replica.Replicas = nil
+	if dbCfg.DSN != "" {
+		replica.DSN = address
+	} else {
+		replica.Address = address
+	}
+	return &replica
+}
+
+func (dbCfg *DatabaseConfig) postgresDSN() string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(dbCfg.User, dbCfg.Password),
+		Host:   dbCfg.Address,
+		Path:   dbCfg.Database,
+	}
+
+	q := url.Values{}
+	switch dbCfg.SSLMode {
+	case "":
+		q.Set("sslmode", "disable")
+	case "preferred":
+		q.Set("sslmode", "prefer")
+	default:
+		q.Set("sslmode", dbCfg.SSLMode)
+	}
+	if dbCfg.SSLRootCert != "" {
+		q.Set("sslrootcert", dbCfg.SSLRootCert)
+	}
+	if dbCfg.SSLCert != "" {
+		q.Set("sslcert", dbCfg.SSLCert)
+	}
+	if dbCfg.SSLKey != "" {
+		q.Set("sslkey", dbCfg.SSLKey)
+	}
+	if dbCfg.Timezone != "" {
+		q.Set("timezone", dbCfg.Timezone)
+	}
+	if dbCfg.Schema != "" {
+		q.Set("search_path", dbCfg.Schema)
+	}
+	if dbCfg.ConnectTimeout > 0 {
+		// in seconds; rounded up, as 0 means no timeout
+		q.Set("connect_timeout", strconv.Itoa(int(math.Ceil(dbCfg.ConnectTimeout.Seconds()))))
+	}
+	if dbCfg.ApplicationName != "" {
+		q.Set("application_name", dbCfg.ApplicationName)
+	}
+	for k, v := range dbCfg.Params {
+		q.Set(k, v)
+	}
+
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (dbCfg *DatabaseConfig) mysqlDSN() string {
+	c := mysql.NewConfig()
+	c.User = dbCfg.User
+	c.Passwd = dbCfg.Password
+	c.Net = "tcp"
+	c.Addr = dbCfg.Address
+	c.DBName = dbCfg.Database
+	c.ParseTime = true
+	c.Params = map[string]string{"charset": "utf8"}
+
+	if dbCfg.Timezone != "" {
+		if loc, err := time.LoadLocation(dbCfg.Timezone); err == nil {
+			c.Loc = loc
+		}
+	}
+	c.Timeout = dbCfg.ConnectTimeout
+	if dbCfg.ApplicationName != "" {
+		c.ConnectionAttributes = "program_name:" + dbCfg.ApplicationName
+	}
+
+	switch dbCfg.SSLMode {
+	case "allow", "prefer", "preferred":
+		c.TLSConfig = "preferred"
+		c.AllowFallbackToPlaintext = true
+	case "require":
+		c.TLSConfig = "skip-verify"
+	case "verify-ca", "verify-full":
+		c.TLSConfig = "true"
+	}
+	if c.TLSConfig != "" && dbCfg.hasCertFiles() {
+		// registered by RegisterTLS
+		c.TLSConfig = dbCfg.tlsConfigName()
+	}
+
+	for k, v := range dbCfg.Params {
+		for _, name := range mysqlParams {
+			if strings.EqualFold(k, name) {
+				k = name
+				break
+			}
+		}
+		c.Params[k] = v
+	}
+	return c.FormatDSN()
+}
+
+// sqliteDSN returns the path of the database file, or :memory:, with the
+// pragmas run on every connection: foreign keys are enforced as in the
+// other drivers, and writers wait for each other instead of failing with
+// SQLITE_BUSY. A _pragma param is run after them.
+func (dbCfg *DatabaseConfig) sqliteDSN() string {
+	q := url.Values{}
+	q.Add("_pragma", "foreign_keys(1)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	for k, v := range dbCfg.Params {
+		if k == "_pragma" {
+			q.Add(k, v)
+		} else {
+			q.Set(k, v)
+		}
+	}
+	return dbCfg.Database + "?" + q.Encode()
+}
+
+func (dbCfg *DatabaseConfig) hasCertFiles() bool {
+	return dbCfg.SSLRootCert != "" || dbCfg.SSLCert != ""
+}
+
+// tlsConfigName is the name of the TLS settings registered in the mysql
+// driver, unique for every combination of address, mode and files.
+func (dbCfg *DatabaseConfig) tlsConfigName() string {
+	h := sha256.Sum256([]byte(strings.Join([]string{
+		dbCfg.Address, dbCfg.SSLMode, dbCfg.SSLRootCert, dbCfg.SSLCert, dbCfg.SSLKey,
+	}, "\x00")))
+	return "morondanga-" + hex.EncodeToString(h[:8])
+}
+
+// RegisterTLS registers the TLS settings built from the certificate files
+// in the mysql driver, which references them by name in the connection
+// string. It does nothing for other drivers, which read the files by
+// themselves, or when TLS is disabled or no files are set.
+func (dbCfg *DatabaseConfig) RegisterTLS() error {
+	if !strings.EqualFold(dbCfg.Driver, "mysql") || dbCfg.DSN != "" || !dbCfg.hasCertFiles() {
+		return nil
+	}
+	if dbCfg.SSLMode == "" || dbCfg.SSLMode == "disable" {
+		return nil
+	}
+
+	tlsCfg, err := dbCfg.TLSConfig()
+	if err != nil {
+		return err
+	}
+	return mysql.RegisterTLSConfig(dbCfg.tlsConfigName(), tlsCfg)
+}
+
+// TLSConfig builds the TLS settings from the certificate files and the mode.
+func (dbCfg *DatabaseConfig) TLSConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if dbCfg.SSLRootCert != "" {
+		pem, err := os.ReadFile(dbCfg.SSLRootCert)
+		if err != nil {
+			return nil, fmt.Errorf("database root certificate: %w", err)
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("database root certificate: no certificates found in %s", dbCfg.SSLRootCert)
+		}
+	}
+	if dbCfg.SSLCert != "" {
+		cert, err := tls.LoadX509KeyPair(dbCfg.SSLCert, dbCfg.SSLKey)
+		if err != nil {
+			return nil, fmt.Errorf("database client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	switch dbCfg.SSLMode {
+	case "verify-full":
+		host, _, err := net.SplitHostPort(dbCfg.Address)
+		if err != nil {
+			host = dbCfg.Address
+		}
+		tlsCfg.ServerName = host
+	case "verify-ca":
+		// verify the chain, but not the host name
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyPeerCertificate = verifyChain(tlsCfg.RootCAs)
+	default:
+		tlsCfg.InsecureSkipVerify = true
+	}
+	return tlsCfg, nil
+}
+
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no server certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+}
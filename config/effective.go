@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// SourceDefault is the source of the values not given by any file,
+// environment variable or flag.
+const SourceDefault = "default"
+
+// EffectiveValue is a configuration value along with the layer it comes from.
+type EffectiveValue struct {
+	// Key is the YAML path of the value, e.g. http.readTimeout.
+	Key string
+	// Value is the value, or Redacted for sensitive settings, as in Dump.
+	Value any
+	// Source is SourceDefault, the path of a configuration file,
+	// env:<VARIABLE> or flag:<name>.
+	Source string
+}
+
+// Effective returns every value of cfg, which must have been loaded by the
+// last Load, along with the layer it comes from. Values are sorted by key.
+// Sensitive values are redacted like Dump does.
+func (l *Loader) Effective(cfg ConfigTemplate) []EffectiveValue {
+	l.mu.Lock()
+	v, layers, flags, envPrefix := l.v, l.layers, l.flags, l.envPrefix
+	l.mu.Unlock()
+
+	var known []string
+	if v != nil {
+		known = v.AllKeys()
+	}
+
+	values := flattenConfig(reflect.ValueOf(cfg), "", false, nil)
+	for i := range values {
+		values[i].Source = valueSource(strings.ToLower(values[i].Key), known, envPrefix, layers, flags)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Key < values[j].Key })
+	return values
+}
+
+// valueSource returns the layer of highest precedence that sets key.
+func valueSource(key string, known []string, envPrefix string, layers []fileLayer, flags *pflag.FlagSet) string {
+	if flags != nil {
+		var source string
+		flags.Visit(func(f *pflag.Flag) {
+			if strings.ToLower(f.Name) == key {
+				source = "flag:" + f.Name
+			}
+		})
+		if source != "" {
+			return source
+		}
+	}
+
+	// viper only takes environment variables for the keys it knows about
+	if slices.Contains(known, key) {
+		name := strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		if envPrefix != "" {
+			name = strings.ToUpper(envPrefix) + "_" + name
+		}
+		if os.Getenv(name) != "" {
+			return "env:" + name
+		}
+	}
+
+	for i := len(layers) - 1; i >= 0; i-- {
+		if layers[i].v.IsSet(key) {
+			return layers[i].file
+		}
+	}
+	return SourceDefault
+}
+
+// flattenConfig returns the leaf values of a configuration, keyed by their
+// YAML path. Lists and durations are leaves. Sensitive values, or all of them
+// when redact is set, are replaced with Redacted.
+func flattenConfig(val reflect.Value, prefix string, redact bool, out []EffectiveValue) []EffectiveValue {
+	for val.Kind() == reflect.Pointer || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return append(out, EffectiveValue{Key: prefix})
+		}
+		val = val.Elem()
+	}
+
+	switch {
+	case val.Type() == reflect.TypeOf(time.Duration(0)):
+		// leaf below
+
+	case val.Kind() == reflect.Struct:
+		for i := 0; i < val.NumField(); i++ {
+			field := val.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if tag := field.Tag.Get("mapstructure"); strings.Contains(tag, ",squash") {
+				out = flattenConfig(val.Field(i), prefix, redact, out)
+				continue
+			}
+			name := yamlFieldName(field)
+			if name == "" {
+				continue
+			}
+			sensitive := redact || redactField(val.Type(), field)
+			out = flattenConfig(val.Field(i), joinKey(prefix, name), sensitive, out)
+			if !sensitive && field.Tag.Get("redact") == "dsn" {
+				last := &out[len(out)-1]
+				last.Value = redactConnStrings(last.Value)
+			}
+		}
+		return out
+
+	case val.Kind() == reflect.Map && val.Type().Key().Kind() == reflect.String:
+		for _, k := range val.MapKeys() {
+			out = flattenConfig(val.MapIndex(k), joinKey(prefix, k.String()), redact || isSensitive(k.String()), out)
+		}
+		return out
+	}
+
+	if redact && !val.IsZero() {
+		return append(out, EffectiveValue{Key: prefix, Value: Redacted})
+	}
+	return append(out, EffectiveValue{Key: prefix, Value: val.Interface()})
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
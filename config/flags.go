@@ -0,0 +1,97 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// NewFlagSet returns a flag set with a flag per setting of cfgTemplate, named
+// after its YAML path, e.g. --http.address or --http.maskedHeaders. The
+// current values of cfgTemplate are the defaults shown in the usage.
+//
+// Bound to a Loader with BindFlags, the flags set in the command line override
+// any other layer; along with SetFileOptional, a service can run without a
+// configuration file. Maps, like the custom section, have no flags.
+func NewFlagSet(name string, cfgTemplate ConfigTemplate) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	walkTemplate(reflect.ValueOf(cfgTemplate), "", func(key string, val reflect.Value) {
+		addFlag(fs, key, val)
+	})
+	return fs
+}
+
+func addFlag(fs *pflag.FlagSet, key string, val reflect.Value) {
+	if val.Type() == durationType {
+		fs.Duration(key, time.Duration(val.Int()), "")
+		return
+	}
+
+	switch val.Kind() {
+	case reflect.String:
+		fs.String(key, val.String(), "")
+	case reflect.Bool:
+		fs.Bool(key, val.Bool(), "")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fs.Int64(key, val.Int(), "")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fs.Uint64(key, val.Uint(), "")
+	case reflect.Float32, reflect.Float64:
+		fs.Float64(key, val.Float(), "")
+	case reflect.Slice:
+		if val.Type().Elem().Kind() == reflect.String {
+			fs.StringSlice(key, val.Interface().([]string), "")
+		}
+	}
+}
+
+// templateKeys returns the keys of all the settings of cfgTemplate.
+func templateKeys(cfgTemplate ConfigTemplate) []string {
+	var keys []string
+	walkTemplate(reflect.ValueOf(cfgTemplate), "", func(key string, _ reflect.Value) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// walkTemplate calls fn with the YAML path and the value of every setting of
+// a configuration. Nested structs are walked, while lists, durations and other
+// scalars are settings; maps are skipped, as their keys are unknown.
+func walkTemplate(val reflect.Value, prefix string, fn func(key string, val reflect.Value)) {
+	for val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			val = reflect.New(val.Type().Elem())
+		}
+		val = val.Elem()
+	}
+
+	switch {
+	case val.Type() == durationType:
+		fn(prefix, val)
+
+	case val.Kind() == reflect.Struct:
+		for i := 0; i < val.NumField(); i++ {
+			field := val.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if tag := field.Tag.Get("mapstructure"); strings.Contains(tag, ",squash") {
+				walkTemplate(val.Field(i), prefix, fn)
+				continue
+			}
+			if name := yamlFieldName(field); name != "" {
+				walkTemplate(val.Field(i), joinKey(prefix, name), fn)
+			}
+		}
+
+	case val.Kind() == reflect.Map, val.Kind() == reflect.Interface, val.Kind() == reflect.Func, val.Kind() == reflect.Chan:
+		// skipped
+
+	default:
+		fn(prefix, val)
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoaderBindsEveryFieldToEnv(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	writeTestFile(t, file, "app:\n  name: from-file\n")
+
+	t.Setenv("MYAPP_APP_NAME", "from-env")
+	t.Setenv("MYAPP_HTTP_ADDRESS", ":9000")
+	t.Setenv("MYAPP_HTTP_MASKEDHEADERS", "X-Secret,X-Token")
+	t.Setenv("MYAPP_HTTP_TLS_MINVERSION", "1.3")
+	t.Setenv("MYAPP_OBSERVABILITY_APIKEY", "api-key")
+	// ignored, because of the prefix
+	t.Setenv("HTTP_READTIMEOUT", "1m")
+
+	l := NewLoader()
+	l.SetEnvPrefix("MYAPP")
+	cfg := Config{}
+	require.NoError(t, l.Load(file, &cfg))
+
+	assert.Equal(t, "from-env", cfg.App.Name)
+	assert.Equal(t, ":9000", cfg.HTTP.Address)
+	assert.Equal(t, []string{"X-Secret", "X-Token"}, cfg.HTTP.MaskedHeaders)
+	assert.Equal(t, "1.3", cfg.HTTP.TLS.MinVersion)
+	assert.Equal(t, "api-key", cfg.Observability.ApiKey)
+	assert.Zero(t, cfg.HTTP.ReadTimeout)
+
+	sources := map[string]string{}
+	for _, v := range l.Effective(&cfg) {
+		sources[v.Key] = v.Source
+	}
+	assert.Equal(t, "env:MYAPP_HTTP_ADDRESS", sources["http.address"])
+}
+
+func TestLoaderWithFlagsOnly(t *testing.T) {
+	t.Parallel()
+
+	fs := NewFlagSet("test", &Config{})
+	require.NoError(t, fs.Parse([]string{
+		"--app.name=flags",
+		"--app.logLevel=1",
+		"--http.readTimeout=3s",
+		"--http.maskedBodyFields=password,token",
+		"--database.port=5432",
+		"--redis.enabled",
+	}))
+
+	l := NewLoader()
+	l.SetFileOptional(true)
+	l.BindFlags(fs)
+	cfg := Config{}
+	require.NoError(t, l.Load(filepath.Join(t.TempDir(), "missing.yml"), &cfg))
+
+	assert.Equal(t, "flags", cfg.App.Name)
+	assert.Equal(t, 1, cfg.App.LogLevel)
+	assert.Equal(t, 3*time.Second, cfg.HTTP.ReadTimeout)
+	assert.Equal(t, []string{"password", "token"}, cfg.HTTP.MaskedBodyFields)
+	assert.Equal(t, uint16(5432), cfg.Database.Port)
+	assert.True(t, cfg.Redis.Enabled)
+
+	// the file is still required by default
+	assert.Error(t, NewLoader().Load(filepath.Join(t.TempDir(), "missing.yml"), &Config{}))
+}
+
+func TestNewFlagSet(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{}
+	cfg.SetDefaults()
+	fs := NewFlagSet("test", &cfg)
+
+	f := fs.Lookup("http.readTimeout")
+	require.NotNil(t, f)
+	assert.Equal(t, DefaultHttpReadTimeout.String(), f.DefValue)
+	assert.NotNil(t, fs.Lookup("http.tls.cipherSuites"))
+	assert.NotNil(t, fs.Lookup("database.connect.lazy"))
+	assert.Nil(t, fs.Lookup("custom"))
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// EnvironmentVariable selects the environment overlay file when the
+// environment is not set with SetEnvironment.
+const EnvironmentVariable = "APP_ENV"
+
+// localOverlay is the name of the optional local override file, e.g.
+// config.local.yml, meant to be ignored by git.
+const localOverlay = "local"
+
+// Loader loads the service configuration from a file and the environment.
+//
+// Every Load uses its own viper instance, so loaders don't share any global
+// state and can be used concurrently, e.g. by several services in the same
+// process or by parallel tests.
+type Loader struct {
+	mu sync.Mutex
+	v  *viper.Viper
+	// path and template type of the last Load, used to reload on changes
+	path     string
+	tmplType reflect.Type
+	// files read by the last Load, from the lowest to the highest precedence,
+	// and the files that would be read if they existed
+	layers     []fileLayer
+	candidates []string
+	// resolvers of secret references by scheme; nil means the built-in ones
+	resolvers    map[string]SecretResolver
+	env          string
+	envPrefix    string
+	fileOptional bool
+	flags        *pflag.FlagSet
+}
+
+// fileLayer is a configuration file merged by Load.
+type fileLayer struct {
+	file string
+	v    *viper.Viper
+}
+
+// NewLoader creates a configuration loader.
+func NewLoader() *Loader {
+	return &Loader{}
+}
+
+// GetConfiguration loads the service configuration.
+func GetConfiguration(configFilePath string, cfgTemplate ConfigTemplate) error {
+	return NewLoader().Load(configFilePath, cfgTemplate)
+}
+
+// SetEnvironment selects the environment overlay, e.g. "production" merges
+// config.production.yml on top of config.yml. It takes precedence over the
+// APP_ENV environment variable.
+func (l *Loader) SetEnvironment(env string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.env = env
+}
+
+// SetEnvPrefix sets the prefix of the environment variables that override the
+// configuration, e.g. with MYAPP, http.address is overridden by
+// MYAPP_HTTP_ADDRESS. Without a prefix, it's HTTP_ADDRESS.
+func (l *Loader) SetEnvPrefix(prefix string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.envPrefix = prefix
+}
+
+// SetFileOptional makes Load succeed when the configuration file doesn't
+// exist, so the configuration can be given only by environment variables,
+// flags and defaults.
+func (l *Loader) SetFileOptional(optional bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fileOptional = optional
+}
+
+// BindFlags makes the flags of fs override the configuration. Flags are
+// matched by key, e.g. a flag named http.address overrides that setting,
+// and only apply when they are set in the command line.
+func (l *Loader) BindFlags(fs *pflag.FlagSet) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flags = fs
+}
+
+// Load reads the configuration files, applies the environment and flag
+// overrides, resolves the secret references and decodes the result into
+// cfgTemplate.
+//
+// Settings are merged in layers, where each layer overrides the previous ones:
+//  1. defaults, applied later by SetDefaults;
+//  2. the base file, e.g. config.yml;
+//  3. the environment overlay, e.g. config.production.yml, if it exists;
+//  4. the local override, e.g. config.local.yml, if it exists;
+//  5. environment variables;
+//  6. flags bound with BindFlags.
+//
+// See Effective to find out which layer each value comes from.
+//
+// Secret references can be used in any string value, including lists and the
+// custom section, like ${env:DB_PASSWORD}, ${file:/run/secrets/db} or
+// ${base64:c2VjcmV0}. More schemes can be added with RegisterSecretResolver.
+func (l *Loader) Load(configFilePath string, cfgTemplate ConfigTemplate) error {
+	v := viper.New()
+
+	// parse config file name
+	dir, fileName, fileExt := parseFileName(configFilePath)
+	if fileName != "" {
+		v.SetConfigName(fileName)
+	} else {
+		v.SetConfigName("config") // use 'config' as default
+	}
+	if fileExt != "" {
+		v.SetConfigType(fileExt)
+	} else {
+		v.SetConfigType("yaml") // use 'yaml' as default
+	}
+
+	l.mu.Lock()
+	env, envPrefix, flags, optional := l.env, l.envPrefix, l.flags, l.fileOptional
+	l.mu.Unlock()
+	if env == "" {
+		env = os.Getenv(EnvironmentVariable)
+	}
+
+	v.AddConfigPath(dir)
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	// AutomaticEnv only works for the keys viper knows about, i.e. those in
+	// the files, so every field of the template is bound explicitly
+	for _, key := range templateKeys(cfgTemplate) {
+		if err := v.BindEnv(key); err != nil {
+			return err
+		}
+	}
+
+	var layers []fileLayer
+	var candidates []string
+	err := v.ReadInConfig()
+	var notFound viper.ConfigFileNotFoundError
+	switch {
+	case err == nil:
+		layers, candidates, err = mergeOverlays(v, env)
+		if err != nil {
+			return err
+		}
+	case optional && errors.As(err, &notFound):
+		// run with environment variables and flags only
+	default:
+		return err
+	}
+
+	if flags != nil {
+		if err := v.BindPFlags(flags); err != nil {
+			return fmt.Errorf("bind flags: %w", err)
+		}
+	}
+
+	if err := resolveSecrets(v, l.secretResolvers()); err != nil {
+		return fmt.Errorf("secret references: %w", err)
+	}
+	if err := v.Unmarshal(cfgTemplate); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.v = v
+	l.path = configFilePath
+	l.tmplType = reflect.TypeOf(cfgTemplate)
+	l.layers = layers
+	l.candidates = candidates
+	l.mu.Unlock()
+	return nil
+}
+
+// mergeOverlays merges the environment overlay and the local override, when
+// they exist, on top of the base file read by v.
+func mergeOverlays(v *viper.Viper, env string) ([]fileLayer, []string, error) {
+	base := v.ConfigFileUsed()
+	candidates := []string{base}
+	layer, err := readLayer(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	layers := []fileLayer{layer}
+
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	var overlays []string
+	if env != "" {
+		overlays = append(overlays, stem+"."+env+ext)
+	}
+	overlays = append(overlays, stem+"."+localOverlay+ext)
+	for _, file := range overlays {
+		candidates = append(candidates, file)
+		if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		v.SetConfigFile(file)
+		if err := v.MergeInConfig(); err != nil {
+			return nil, nil, fmt.Errorf("merge %s: %w", file, err)
+		}
+		layer, err := readLayer(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		layers = append(layers, layer)
+	}
+	return layers, candidates, nil
+}
+
+// readLayer reads a single configuration file, to tell later which settings
+// it contains.
+func readLayer(file string) (fileLayer, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return fileLayer{}, fmt.Errorf("read %s: %w", file, err)
+	}
+	return fileLayer{file: file, v: v}, nil
+}
+
+// fork returns a new loader with the same settings, without anything loaded.
+func (l *Loader) fork() *Loader {
+	resolvers := l.secretResolvers()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &Loader{
+		resolvers:    resolvers,
+		env:          l.env,
+		envPrefix:    l.envPrefix,
+		fileOptional: l.fileOptional,
+		flags:        l.flags,
+	}
+}
+
+// Viper returns the viper instance used by the last Load, or nil if nothing
+// was loaded yet.
+func (l *Loader) Viper() *viper.Viper {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.v
+}
+
+func parseFileName(f string) (string, string, string) {
+	dir := filepath.Dir(f)
+	file := filepath.Base(f)
+	ext := filepath.Ext(file)
+
+	if ext != "" {
+		sepIndex := strings.LastIndex(file, ".")
+		fileName := file[0:sepIndex]
+		return dir, fileName, strings.Replace(ext, ".", "", 1)
+	}
+
+	return dir, file, ""
+}
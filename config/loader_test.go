@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoaderParallel(t *testing.T) {
+	type customConfig struct {
+		Config
+		MyCustomSection struct {
+			Key1 string
+		}
+	}
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+		for range 20 {
+			cfg := Config{}
+			l := NewLoader()
+			require.NoError(t, l.Load("testdata/config.yml", &cfg))
+			assert.Equal(t, "MyApp", cfg.GetApp().Name)
+			assert.Equal(t, "127.0.0.1:8080", cfg.GetHTTP().Address)
+		}
+	})
+	t.Run("custom", func(t *testing.T) {
+		t.Parallel()
+		for range 20 {
+			cfg := customConfig{}
+			l := NewLoader()
+			require.NoError(t, l.Load("testdata/config-custom.yml", &cfg))
+			assert.Equal(t, "MyApp", cfg.GetApp().Name)
+			assert.Equal(t, "value", cfg.MyCustomSection.Key1)
+		}
+	})
+}
+
+func TestLoaderDoesNotLeakConfigPaths(t *testing.T) {
+	t.Parallel()
+
+	l := NewLoader()
+	cfg := Config{}
+	require.NoError(t, l.Load("testdata/config.yml", &cfg))
+
+	// a second load must not find the file through the path added by the first one
+	err := l.Load("missing/config.yml", &Config{})
+	assert.Error(t, err)
+
+	// the previous viper instance is kept when a load fails
+	assert.Equal(t, "MyApp", l.Viper().GetString("app.name"))
+}
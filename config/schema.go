@@ -0,0 +1,161 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// durationPattern matches the durations accepted by time.ParseDuration.
+const durationPattern = `^[-+]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`
+
+// Schema returns a JSON Schema (draft 2020-12) of the configuration file
+// described by cfgTemplate, which can be used by editors for autocompletion
+// and to lint configuration files in CI.
+//
+// Unknown keys are accepted, as the loader ignores them and reads keys in any
+// case, so the schema doesn't fail files that load fine. The min, max and
+// oneof validation rules are included; other rules, like the ones depending
+// on several settings, are only checked by Validate.
+func Schema(cfgTemplate ConfigTemplate) ([]byte, error) {
+	t := reflect.TypeOf(cfgTemplate)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	schema := typeSchema(t)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = t.Name()
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == durationType {
+		// durations are also decoded from integers, as nanoseconds
+		return map[string]any{
+			"type":    []string{"string", "integer"},
+			"pattern": durationPattern,
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		schema := map[string]any{"type": "object"}
+		if t.Elem().Kind() != reflect.Interface {
+			schema["additionalProperties"] = typeSchema(t.Elem())
+		}
+		return schema
+	case reflect.Struct:
+		properties := map[string]any{}
+		structSchema(t, properties)
+		return map[string]any{
+			"type":       "object",
+			"properties": properties,
+		}
+	}
+	// interfaces and anything else accept any value
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type, properties map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if tag := field.Tag.Get("mapstructure"); strings.Contains(tag, ",squash") {
+			if inner := indirectType(field.Type); inner.Kind() == reflect.Struct {
+				structSchema(inner, properties)
+			}
+			continue
+		}
+		name := yamlFieldName(field)
+		if name == "" {
+			continue
+		}
+
+		schema := typeSchema(field.Type)
+		applyRules(schema, field.Tag.Get("validate"))
+		properties[name] = schema
+	}
+}
+
+// applyRules adds the validation rules that JSON Schema can express. The
+// required rule is left out, since values can come from other layers, like
+// environment variables. With omitempty, an empty string is accepted too.
+func applyRules(schema map[string]any, rules string) {
+	numeric := schema["type"] == "integer" || schema["type"] == "number"
+	omitEmpty := false
+	for _, rule := range strings.Split(rules, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "omitempty":
+			omitEmpty = true
+		case "oneof":
+			enum := []any{}
+			if omitEmpty && schema["type"] == "string" {
+				enum = append(enum, "")
+			}
+			for _, v := range strings.Fields(param) {
+				if n, err := strconv.ParseFloat(v, 64); err == nil && numeric {
+					enum = append(enum, n)
+				} else {
+					enum = append(enum, v)
+				}
+			}
+			schema["enum"] = enum
+		case "min", "max":
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				continue
+			}
+			if keyword := limitKeyword(schema["type"], name); keyword != "" {
+				schema[keyword] = n
+			}
+		}
+	}
+}
+
+// limitKeyword returns the JSON Schema keyword of a min or max rule, whose
+// meaning depends on the type, like in the validator.
+func limitKeyword(typ any, rule string) string {
+	t, _ := typ.(string)
+	keywords := map[string][2]string{
+		"integer": {"minimum", "maximum"},
+		"number":  {"minimum", "maximum"},
+		"string":  {"minLength", "maxLength"},
+		"array":   {"minItems", "maxItems"},
+	}
+	k, ok := keywords[t]
+	if !ok {
+		return ""
+	}
+	if rule == "min" {
+		return k[0]
+	}
+	return k[1]
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// SecretResolver resolves the secret references of a scheme. For a value like
+// ${vault:db/password}, the resolver registered for "vault" is given
+// "db/password".
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to the SecretResolver interface.
+type SecretResolverFunc func(ref string) (string, error)
+
+// Resolve calls f(ref).
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// secretRefPattern matches references like ${env:DB_PASSWORD}. A value may
+// contain several references mixed with literal text.
+var secretRefPattern = regexp.MustCompile(`\$\{([a-zA-Z][a-zA-Z0-9_-]*):([^}]*)\}`)
+
+// defaultSecretResolvers returns the built-in schemes:
+//   - env: the value of an environment variable, which must be set;
+//   - file: the content of a file, without trailing new lines, e.g. Docker
+//     and Kubernetes secrets mounted under /run/secrets;
+//   - base64: the decoded value.
+func defaultSecretResolvers() map[string]SecretResolver {
+	return map[string]SecretResolver{
+		"env": SecretResolverFunc(func(ref string) (string, error) {
+			val, ok := os.LookupEnv(ref)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s not set", ref)
+			}
+			return val, nil
+		}),
+		"file": SecretResolverFunc(func(ref string) (string, error) {
+			content, err := os.ReadFile(ref)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimRight(string(content), "\r\n"), nil
+		}),
+		"base64": SecretResolverFunc(func(ref string) (string, error) {
+			val, err := base64.StdEncoding.DecodeString(ref)
+			if err != nil {
+				return "", err
+			}
+			return string(val), nil
+		}),
+	}
+}
+
+// RegisterSecretResolver adds a scheme for secret references, or replaces
+// a built-in one (env, file and base64).
+func (l *Loader) RegisterSecretResolver(scheme string, r SecretResolver) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.resolvers == nil {
+		l.resolvers = defaultSecretResolvers()
+	}
+	l.resolvers[scheme] = r
+}
+
+// secretResolvers returns a copy of the registered resolvers.
+func (l *Loader) secretResolvers() map[string]SecretResolver {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.resolvers == nil {
+		return defaultSecretResolvers()
+	}
+	resolvers := make(map[string]SecretResolver, len(l.resolvers))
+	for scheme, r := range l.resolvers {
+		resolvers[scheme] = r
+	}
+	return resolvers
+}
+
+// resolveSecrets replaces the secret references found in any string value,
+// including lists and the custom section, before the configuration is
+// decoded. The values themselves are never included in the errors.
+func resolveSecrets(v *viper.Viper, resolvers map[string]SecretResolver) error {
+	var errs []error
+	for _, key := range v.AllKeys() {
+		val, changed, err := resolveSecretValue(v.Get(key), resolvers)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		if changed {
+			v.Set(key, val)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func resolveSecretValue(val any, resolvers map[string]SecretResolver) (any, bool, error) {
+	switch val := val.(type) {
+	case string:
+		return resolveSecretString(val, resolvers)
+
+	case []string:
+		out := make([]string, len(val))
+		changed := false
+		for i, item := range val {
+			resolved, ok, err := resolveSecretString(item, resolvers)
+			if err != nil {
+				return nil, false, err
+			}
+			out[i] = resolved.(string)
+			changed = changed || ok
+		}
+		return out, changed, nil
+
+	case []any:
+		out := make([]any, len(val))
+		changed := false
+		for i, item := range val {
+			resolved, ok, err := resolveSecretValue(item, resolvers)
+			if err != nil {
+				return nil, false, err
+			}
+			out[i] = resolved
+			changed = changed || ok
+		}
+		return out, changed, nil
+
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		changed := false
+		for k, item := range val {
+			resolved, ok, err := resolveSecretValue(item, resolvers)
+			if err != nil {
+				return nil, false, err
+			}
+			out[k] = resolved
+			changed = changed || ok
+		}
+		return out, changed, nil
+	}
+	return val, false, nil
+}
+
+func resolveSecretString(s string, resolvers map[string]SecretResolver) (any, bool, error) {
+	if !strings.Contains(s, "${") {
+		return s, false, nil
+	}
+
+	var errs []error
+	out := secretRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		m := secretRefPattern.FindStringSubmatch(ref)
+		scheme, name := m[1], m[2]
+		r, ok := resolvers[scheme]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown secret scheme %q", scheme))
+			return ref
+		}
+		val, err := r.Resolve(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resolve %s secret: %w", scheme, err))
+			return ref
+		}
+		return val
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, false, err
+	}
+	return out, out != s, nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoaderResolvesSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "db-password")
+	require.NoError(t, os.WriteFile(secretFile, []byte("from-file\n"), 0o600))
+
+	t.Setenv("TEST_SECRETS_JWT_KEY", "from-env")
+
+	file := filepath.Join(dir, "config.yml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+http:
+  jwtSigningKey: "${env:TEST_SECRETS_JWT_KEY}"
+  maskedHeaders: ["${base64:WC1TZWNyZXQ=}"]
+database:
+  password: "${file:`+secretFile+`}"
+  user: "user-${vault:db/user}"
+custom:
+  token: "${base64:dG9rZW4=}"
+`), 0o600))
+
+	l := NewLoader()
+	l.RegisterSecretResolver("vault", SecretResolverFunc(func(ref string) (string, error) {
+		return strings.ReplaceAll(ref, "/", "-"), nil
+	}))
+
+	cfg := Config{}
+	require.NoError(t, l.Load(file, &cfg))
+	assert.Equal(t, "from-env", cfg.HTTP.JwtSigningKey)
+	assert.Equal(t, []string{"X-Secret"}, cfg.HTTP.MaskedHeaders)
+	assert.Equal(t, "from-file", cfg.Database.Password)
+	assert.Equal(t, "user-db-user", cfg.Database.User)
+	token, _ := cfg.GetCustomValue("token")
+	assert.Equal(t, "token", token)
+}
+
+func TestLoaderSecretErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+http:
+  jwtSigningKey: "${env:TEST_SECRETS_NOT_SET}"
+redis:
+  password: "${unknown:value}"
+`), 0o600))
+
+	err := NewLoader().Load(file, &Config{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "http.jwtsigningkey: resolve env secret: environment variable TEST_SECRETS_NOT_SET not set")
+	assert.Contains(t, err.Error(), `redis.password: unknown secret scheme "unknown"`)
+}
+
+func TestResolveSecretString(t *testing.T) {
+	resolvers := defaultSecretResolvers()
+	resolvers["fail"] = SecretResolverFunc(func(string) (string, error) {
+		return "", errors.New("boom")
+	})
+
+	val, changed, err := resolveSecretString("plain value", resolvers)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, "plain value", val)
+
+	val, changed, err = resolveSecretString("${base64:YQ==}-${base64:Yg==}", resolvers)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "a-b", val)
+
+	_, _, err = resolveSecretString("${fail:x}", resolvers)
+	assert.EqualError(t, err, "resolve fail secret: boom")
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// databaseDrivers are the supported values of DatabaseConfig.Driver.
+var databaseDrivers = []string{"mysql", "postgres", "sqlite"}
+
+var (
+	validate     *validator.Validate
+	validateOnce sync.Once
+)
+
+// ValidationError describes a configuration value that breaks a rule.
+type ValidationError struct {
+	// Path is the YAML path of the value, e.g. http.readTimeout.
+	Path string
+	// Rule is the name of the failed rule, e.g. required or oneof.
+	Rule  string
+	Param string
+}
+
+func (e ValidationError) Error() string {
+	return e.Path + ": " + e.message()
+}
+
+func (e ValidationError) message() string {
+	switch e.Rule {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		return "must be at least " + e.Param
+	case "max", "lte":
+		return "must be at most " + e.Param
+	case "oneof":
+		return "must be one of [" + e.Param + "]"
+	case "dbdriver":
+		return "must be one of [" + strings.Join(databaseDrivers, " ") + "]"
+	case "filemode":
+		return "must be an octal file mode, e.g. 0660"
+	case "url":
+		return "must be a valid URL"
+	case "timezone":
+		return "must be a valid time zone, e.g. UTC or Europe/Madrid"
+	case "noreplicas":
+		return "not supported by the sqlite driver"
+	}
+	if e.Param != "" {
+		return fmt.Sprintf("failed on the '%s=%s' rule", e.Rule, e.Param)
+	}
+	return fmt.Sprintf("failed on the '%s' rule", e.Rule)
+}
+
+// ValidationErrors holds all the rules broken by a configuration.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks the `validate` struct tags of a configuration, including any
+// custom section of a ConfigTemplate, using go-playground/validator. All the
+// violations are returned at once as ValidationErrors, each one with the YAML
+// path of the offending value.
+//
+// It is meant to run after SetDefaults, so zero values that have a default
+// don't need to be accepted by the rules.
+func Validate(cfg any) error {
+	validateOnce.Do(initValidator)
+
+	err := validate.Struct(cfg)
+	if err == nil {
+		return nil
+	}
+	fieldErrs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err
+	}
+
+	errs := make(ValidationErrors, 0, len(fieldErrs))
+	for _, fe := range fieldErrs {
+		errs = append(errs, ValidationError{
+			Path:  yamlPath(fe.Namespace()),
+			Rule:  fe.Tag(),
+			Param: fe.Param(),
+		})
+	}
+	return errs
+}
+
+// Validate checks the configuration rules. See the Validate function.
+func (cfg *Config) Validate() error {
+	return Validate(cfg)
+}
+
+// ValidateTemplate checks a configuration template, as done on startup and
+// on every reload. The Validate function checks the `validate` tags of the
+// whole template, custom sections included, and then the Validate method of
+// the template runs, so a template can override it to add its own rules.
+func ValidateTemplate(cfg ConfigTemplate) error {
+	if err := Validate(cfg); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+func initValidator() {
+	validate = validator.New()
+	validate.RegisterTagNameFunc(yamlFieldName)
+	_ = validate.RegisterValidation("filemode", func(fl validator.FieldLevel) bool {
+		_, err := strconv.ParseUint(fl.Field().String(), 8, 32)
+		return err == nil
+	})
+
+	_ = validate.RegisterValidation("timezone", func(fl validator.FieldLevel) bool {
+		_, err := time.LoadLocation(fl.Field().String())
+		return err == nil
+	})
+
+	validate.RegisterStructValidation(validateHttpConfig, HttpConfig{})
+	validate.RegisterStructValidation(validateTLSConfig, TLSConfig{})
+	validate.RegisterStructValidation(validateDatabaseConfig, DatabaseConfig{})
+	validate.RegisterStructValidation(validateRedisConfig, RedisConfig{})
+}
+
+func validateHttpConfig(sl validator.StructLevel) {
+	httpCfg := sl.Current().Interface().(HttpConfig)
+	if httpCfg.JwtEnabled && httpCfg.JwtSigningKey == "" {
+		sl.ReportError(httpCfg.JwtSigningKey, "jwtSigningKey", "JwtSigningKey", "required", "")
+	}
+}
+
+func validateTLSConfig(sl validator.StructLevel) {
+	tlsCfg := sl.Current().Interface().(TLSConfig)
+	if !tlsCfg.Enabled {
+		return
+	}
+	if tlsCfg.CertFile == "" {
+		sl.ReportError(tlsCfg.CertFile, "certFile", "CertFile", "required", "")
+	}
+	if tlsCfg.KeyFile == "" {
+		sl.ReportError(tlsCfg.KeyFile, "keyFile", "KeyFile", "required", "")
+	}
+}
+
+func validateDatabaseConfig(sl validator.StructLevel) {
+	dbCfg := sl.Current().Interface().(DatabaseConfig)
+	if !dbCfg.Enabled {
+		return
+	}
+	if !slices.Contains(databaseDrivers, strings.ToLower(dbCfg.Driver)) {
+		sl.ReportError(dbCfg.Driver, "driver", "Driver", "dbdriver", "")
+	}
+	if strings.EqualFold(dbCfg.Driver, "sqlite") {
+		// the database is a file, or :memory:
+		if dbCfg.Database == "" && dbCfg.DSN == "" {
+			sl.ReportError(dbCfg.Database, "database", "Database", "required", "")
+		}
+		if len(dbCfg.Replicas) > 0 {
+			sl.ReportError(dbCfg.Replicas, "replicas", "Replicas", "noreplicas", "")
+		}
+		return
+	}
+	if dbCfg.Address == "" && dbCfg.DSN == "" {
+		sl.ReportError(dbCfg.Address, "address", "Address", "required", "")
+	}
+}
+
+func validateRedisConfig(sl validator.StructLevel) {
+	redisCfg := sl.Current().Interface().(RedisConfig)
+	if redisCfg.Enabled && redisCfg.Address == "" {
+		sl.ReportError(redisCfg.Address, "address", "Address", "required", "")
+	}
+}
+
+// yamlFieldName returns the name of a field as written in the YAML file:
+// the mapstructure tag when present, or the field name in lowerCamelCase.
+func yamlFieldName(f reflect.StructField) string {
+	if tag, ok := f.Tag.Lookup("mapstructure"); ok {
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return lowerCamel(f.Name)
+}
+
+// lowerCamel lowercases the leading upper case run of a name, digits
+// included, keeping the start of the next word: HTTP -> http, H2C -> h2c,
+// ApiKey -> apiKey, TLSConfig -> tlsConfig.
+func lowerCamel(name string) string {
+	r := []rune(name)
+	for i := range r {
+		if !unicode.IsUpper(r[i]) && !unicode.IsDigit(r[i]) {
+			break
+		}
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+// yamlPath strips the name of the top level type from a validator namespace.
+func yamlPath(namespace string) string {
+	if _, path, ok := strings.Cut(namespace, "."); ok {
+		return path
+	}
+	return namespace
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDefaults(t *testing.T) {
+	cfg := Config{}
+	cfg.SetDefaults()
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateAggregatesErrors(t *testing.T) {
+	cfg := Config{}
+	cfg.SetDefaults()
+	cfg.HTTP.ReadTimeout = -time.Second
+	cfg.HTTP.JwtEnabled = true
+	cfg.HTTP.TLS = TLSConfig{Enabled: true, MinVersion: "1.4"}
+	cfg.Database = DatabaseConfig{Enabled: true, Driver: "mongo", Address: "localhost"}
+	cfg.Database.Connect.SetDefaults()
+
+	err := cfg.Validate()
+	require.Error(t, err)
+
+	var verrs ValidationErrors
+	require.True(t, errors.As(err, &verrs))
+
+	paths := map[string]string{}
+	for _, e := range verrs {
+		paths[e.Path] = e.Rule
+	}
+	assert.Equal(t, map[string]string{
+		"http.readTimeout":    "min",
+		"http.jwtSigningKey":  "required",
+		"http.tls.minVersion": "oneof",
+		"http.tls.certFile":   "required",
+		"http.tls.keyFile":    "required",
+		"database.driver":     "dbdriver",
+	}, paths)
+	assert.Contains(t, err.Error(), "http.tls.minVersion: must be one of [1.0 1.1 1.2 1.3]")
+}
+
+func TestValidateCustomSections(t *testing.T) {
+	type myCustomConfiguration struct {
+		Config
+		MyCustomSection struct {
+			Key1 string `validate:"required"`
+			Key2 int    `validate:"min=1"`
+		}
+	}
+
+	cfg := myCustomConfiguration{}
+	cfg.SetDefaults()
+	cfg.App.LogFormat = "xml"
+
+	err := Validate(&cfg)
+	var verrs ValidationErrors
+	require.True(t, errors.As(err, &verrs))
+
+	paths := []string{}
+	for _, e := range verrs {
+		paths = append(paths, e.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		"config.app.logFormat",
+		"myCustomSection.key1",
+		"myCustomSection.key2",
+	}, paths)
+}
+
+// kafkaConfig is a custom template with its own rules.
+type kafkaConfig struct {
+	Config
+	Kafka struct {
+		Brokers []string `validate:"required"`
+		Acks    int
+	}
+}
+
+func (c *kafkaConfig) Validate() error {
+	if c.Kafka.Acks > len(c.Kafka.Brokers) {
+		return errors.New("kafka.acks: more than the number of brokers")
+	}
+	return nil
+}
+
+func TestValidateTemplate(t *testing.T) {
+	cfg := &kafkaConfig{}
+	cfg.SetDefaults()
+	cfg.Kafka.Brokers = []string{"kafka-1:9092"}
+	assert.NoError(t, ValidateTemplate(cfg))
+
+	// the override is used
+	cfg.Kafka.Acks = 2
+	assert.EqualError(t, ValidateTemplate(cfg), "kafka.acks: more than the number of brokers")
+
+	// the tags are checked before the override
+	cfg.Kafka.Brokers = nil
+	var verrs ValidationErrors
+	require.ErrorAs(t, ValidateTemplate(cfg), &verrs)
+
+	// without override, the tags of the custom sections are checked too
+	type plainConfig struct {
+		Config
+		Kafka struct {
+			Brokers []string `validate:"required"`
+		}
+	}
+	plain := &plainConfig{}
+	plain.SetDefaults()
+	require.ErrorAs(t, ValidateTemplate(plain), &verrs)
+	require.Len(t, verrs, 1)
+	assert.Equal(t, "kafka.brokers", verrs[0].Path)
+}
+
+func TestLowerCamel(t *testing.T) {
+	tests := map[string]string{
+		"HTTP":          "http",
+		"ApiKey":        "apiKey",
+		"TLS":           "tls",
+		"H2C":           "h2c",
+		"X509Cert":      "x509Cert",
+		"ClientCAFile":  "clientCAFile",
+		"JwtSigningKey": "jwtSigningKey",
+		"name":          "name",
+	}
+	for input, want := range tests {
+		assert.Equal(t, want, lowerCamel(input), input)
+	}
+}
+
+func TestValidateDatabases(t *testing.T) {
+	cfg := Config{
+		Databases: map[string]*DatabaseConfig{
+			"reporting": {Enabled: true, Driver: "postgres", Address: "reporting:5432"},
+			"legacy":    {Enabled: true, Driver: "mongo", ReplicaPolicy: "sticky"},
+			"archive":   {Driver: "mongo"},
+			"local":     {Enabled: true, Driver: "sqlite", Database: ":memory:"},
+			"cache":     {Enabled: true, Driver: "sqlite", Replicas: []string{"cache-2.db"}},
+		},
+	}
+	cfg.SetDefaults()
+	assert.Equal(t, DefaultDatabaseMaxOpenConns, cfg.Databases["reporting"].MaxOpenConns)
+
+	err := cfg.Validate()
+	var verrs ValidationErrors
+	require.True(t, errors.As(err, &verrs))
+
+	paths := map[string]string{}
+	for _, e := range verrs {
+		paths[e.Path] = e.Rule
+	}
+	assert.Equal(t, map[string]string{
+		"databases[legacy].driver":        "dbdriver",
+		"databases[legacy].address":       "required",
+		"databases[legacy].replicaPolicy": "oneof",
+		"databases[cache].database":       "required",
+		"databases[cache].replicas":       "noreplicas",
+	}, paths)
+	assert.Contains(t, err.Error(), "databases[cache].replicas: not supported by the sqlite driver")
+}
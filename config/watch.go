@@ -0,0 +1,99 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce coalesces the bursts of events produced by a single save.
+var watchDebounce = 100 * time.Millisecond
+
+// Watch reloads the configuration files of the last Load every time one of
+// them changes, until ctx is done. That includes the overlay files created
+// after the last Load.
+//
+// Each reload is decoded into a new instance of the template type, and then
+// SetDefaults and ValidateTemplate are applied. A valid configuration is
+// passed to onChange; otherwise, the error is passed to onError and the
+// change is ignored. Replacing a file or the symlink pointing to it, as
+// Kubernetes does with ConfigMaps, counts as a change.
+func (l *Loader) Watch(ctx context.Context, onChange func(ConfigTemplate), onError func(error)) error {
+	l.mu.Lock()
+	path, tmplType, candidates := l.path, l.tmplType, l.candidates
+	l.mu.Unlock()
+	if len(candidates) == 0 {
+		return errors.New("watch configuration: nothing loaded")
+	}
+	if tmplType.Kind() != reflect.Pointer {
+		return fmt.Errorf("watch configuration: template %s is not a pointer", tmplType)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("watch configuration: %w", err)
+	}
+	defer watcher.Close()
+
+	// the directories are watched, to catch files replaced by renames
+	realFiles := make(map[string]string, len(candidates))
+	for _, file := range candidates {
+		file = filepath.Clean(file)
+		realFiles[file], _ = filepath.EvalSymlinks(file)
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			return fmt.Errorf("watch configuration: %w", err)
+		}
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			for file, realFile := range realFiles {
+				currentFile, _ := filepath.EvalSymlinks(file)
+				if (filepath.Clean(event.Name) == file && event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Remove)) ||
+					currentFile != realFile {
+					realFiles[file] = currentFile
+					reload = time.After(watchDebounce)
+				}
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			onError(fmt.Errorf("watch configuration: %w", err))
+
+		case <-reload:
+			reload = nil
+			// load with a new loader, so a rejected change isn't kept
+			next := l.fork()
+			cfg := reflect.New(tmplType.Elem()).Interface().(ConfigTemplate)
+			if err := next.Load(path, cfg); err != nil {
+				onError(fmt.Errorf("reload configuration: %w", err))
+				continue
+			}
+			cfg.SetDefaults()
+			if err := ValidateTemplate(cfg); err != nil {
+				onError(fmt.Errorf("reload configuration: %w", err))
+				continue
+			}
+
+			l.mu.Lock()
+			l.v, l.layers = next.v, next.layers
+			l.mu.Unlock()
+			onChange(cfg)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoaderWatch(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(file, []byte("app:\n  name: first\n"), 0o600))
+
+	l := NewLoader()
+	require.NoError(t, l.Load(file, &Config{}))
+
+	changes := make(chan ConfigTemplate, 1)
+	errs := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- l.Watch(ctx, func(cfg ConfigTemplate) { changes <- cfg }, func(err error) { errs <- err })
+	}()
+	// give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(file, []byte("app:\n  name: second\n  logLevel: 1\n"), 0o600))
+	select {
+	case cfg := <-changes:
+		assert.Equal(t, "second", cfg.GetApp().Name)
+		assert.Equal(t, 1, cfg.GetApp().LogLevel)
+		// defaults are applied to reloaded configurations
+		assert.Equal(t, DefaultHttpReadTimeout, cfg.GetHTTP().ReadTimeout)
+	case err := <-errs:
+		t.Fatalf("unexpected reload error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration not reloaded")
+	}
+
+	// an invalid configuration is rejected, and the last good one is kept
+	require.NoError(t, os.WriteFile(file, []byte("app:\n  name: third\n  logLevel: 9\n"), 0o600))
+	select {
+	case err := <-errs:
+		assert.ErrorContains(t, err, "app.logLevel")
+	case <-changes:
+		t.Fatal("invalid configuration applied")
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration not reloaded")
+	}
+	assert.Equal(t, "second", l.Viper().GetString("app.name"))
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestLoaderWatchValidateOverride(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(file, []byte("kafka:\n  brokers: [kafka-1]\n"), 0o600))
+
+	l := NewLoader()
+	require.NoError(t, l.Load(file, &kafkaConfig{}))
+
+	errs := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = l.Watch(ctx, func(ConfigTemplate) { t.Error("invalid configuration applied") }, func(err error) { errs <- err })
+	}()
+	// give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(file, []byte("kafka:\n  brokers: [kafka-1]\n  acks: 2\n"), 0o600))
+	select {
+	case err := <-errs:
+		assert.ErrorContains(t, err, "kafka.acks: more than the number of brokers")
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration not reloaded")
+	}
+}
+
+func TestLoaderWatchNothingLoaded(t *testing.T) {
+	t.Parallel()
+
+	err := NewLoader().Watch(context.Background(), func(ConfigTemplate) {}, func(error) {})
+	assert.Error(t, err)
+}
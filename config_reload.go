@@ -0,0 +1,63 @@
+package morondanga
+
+import (
+	"context"
+
+	"github.com/rwbm/morondanga/config"
+	"github.com/rwbm/morondanga/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ConfigChangeFunc is called when the configuration is reloaded, with the
+// previous and the new configuration.
+type ConfigChangeFunc func(old, new config.ConfigTemplate)
+
+// OnConfigChange registers fn to be called every time the configuration file
+// is reloaded, which requires app.watchConfig. Functions are called in
+// registration order, after the built-in settings have been applied, and only
+// with configurations that passed validation.
+//
+// The new configuration is also returned by Configuration() from then on.
+func (s *Service) OnConfigChange(fn ConfigChangeFunc) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	s.cfgSubscribers = append(s.cfgSubscribers, fn)
+}
+
+// watchConfig reloads the configuration in a background worker, which is
+// stopped on Shutdown.
+func (s *Service) watchConfig() {
+	if s.loader == nil {
+		s.Log().Warn("Configuration watch ignored: the configuration was not loaded from a file")
+		return
+	}
+
+	onError := func(err error) {
+		s.Log().Error("Configuration reload rejected", zap.Error(err))
+	}
+	s.Go("config-watcher", func(ctx context.Context) error {
+		return s.loader.Watch(ctx, s.applyConfig, onError)
+	}, WithRestartPolicy(RestartOnFailure))
+}
+
+// applyConfig makes cfg the current configuration, applies the settings that
+// can change live and notifies the subscribers.
+func (s *Service) applyConfig(cfg config.ConfigTemplate) {
+	s.cfgMu.Lock()
+	old := s.cfg
+	s.cfg = cfg
+	subscribers := append([]ConfigChangeFunc(nil), s.cfgSubscribers...)
+	s.cfgMu.Unlock()
+
+	if lvl := cfg.GetApp().LogLevel; lvl != old.GetApp().LogLevel {
+		logging.AtomicLevel().SetLevel(zapcore.Level(lvl))
+	}
+	s.web.Store(newWebSettings(cfg))
+
+	s.Log().Info("Configuration reloaded")
+
+	for _, fn := range subscribers {
+		fn(old, cfg)
+	}
+}
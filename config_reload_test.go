@@ -0,0 +1,85 @@
+package morondanga
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rwbm/morondanga/config"
+	"github.com/rwbm/morondanga/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestServiceConfigReload(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	file := filepath.Join(t.TempDir(), "config.yml")
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	}
+	writeConfig(`
+app:
+  logLevel: 0
+  watchConfig: true
+http:
+  address: 127.0.0.1:0
+`)
+
+	s, err := NewService(file)
+	require.NoError(t, err)
+	defer func() { _ = s.Shutdown(context.Background()) }()
+
+	type change struct{ old, new config.ConfigTemplate }
+	changes := make(chan change, 1)
+	s.OnConfigChange(func(old, new config.ConfigTemplate) {
+		changes <- change{old, new}
+	})
+	// give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	writeConfig(`
+app:
+  logLevel: 1
+  watchConfig: true
+http:
+  address: 127.0.0.1:0
+  maskedHeaders: ["X-Secret"]
+observability:
+  excludedPaths: ["/internal"]
+`)
+
+	var c change
+	select {
+	case c = <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration not reloaded")
+	}
+	assert.Equal(t, 0, c.old.GetApp().LogLevel)
+	assert.Equal(t, 1, c.new.GetApp().LogLevel)
+	assert.Same(t, c.new, s.Configuration())
+
+	// settings applied live
+	assert.Equal(t, zapcore.WarnLevel, logging.AtomicLevel().Level())
+	web := s.web.Load()
+	assert.Equal(t, []string{"X-Secret"}, web.maskedHeaders)
+	assert.True(t, web.excluded("/internal/debug"))
+
+	// an invalid change is rejected
+	writeConfig(`
+app:
+  logLevel: 1
+  logFormat: xml
+  watchConfig: true
+`)
+	select {
+	case <-changes:
+		t.Fatal("invalid configuration applied")
+	case <-time.After(500 * time.Millisecond):
+	}
+	assert.Same(t, c.new, s.Configuration())
+}
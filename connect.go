@@ -0,0 +1,75 @@
+package morondanga
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rwbm/morondanga/config"
+	"go.uber.org/zap"
+)
+
+// connectDependency connects a dependency following its connection settings.
+// In lazy mode, it returns right away and connects in a background worker,
+// which is cancelled on Shutdown. The worker is restarted whenever its
+// attempts run out, so it keeps trying until the dependency is available.
+func (s *Service) connectDependency(name string, cfg config.ConnectConfig, connect func(ctx context.Context) error) error {
+	if cfg.Lazy {
+		initial, max := cfg.InitialBackoff, cfg.MaxBackoff
+		if initial <= 0 {
+			initial = DefaultWorkerInitialBackoff
+		}
+		if max <= 0 {
+			max = DefaultWorkerMaxBackoff
+		}
+		s.Log().Info("Connecting in the background", zap.String("dependency", name))
+		s.Go(name+"-connect", func(ctx context.Context) error {
+			return s.connectWithRetry(ctx, name, cfg, connect)
+		}, WithRestartPolicy(RestartOnFailure), WithRestartBackoff(initial, max))
+		return nil
+	}
+	return s.connectWithRetry(context.Background(), name, cfg, connect)
+}
+
+// connectWithRetry calls connect until it succeeds, up to cfg.Attempts times and
+// within cfg.Timeout, waiting with exponential backoff between attempts.
+// Every failed attempt is logged.
+func (s *Service) connectWithRetry(ctx context.Context, name string, cfg config.ConnectConfig, connect func(ctx context.Context) error) error {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	attempts := cfg.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err = connect(ctx); err == nil {
+			if attempt > 0 {
+				s.Log().Info("Connected", zap.String("dependency", name), zap.Int("attempt", attempt+1))
+			}
+			return nil
+		}
+
+		s.Log().Warn("Connection attempt failed",
+			zap.String("dependency", name),
+			zap.Int("attempt", attempt+1),
+			zap.Int("maxAttempts", attempts),
+			zap.Error(err),
+		)
+		if attempt+1 == attempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s connection deadline exceeded: %w", name, err)
+		case <-time.After(backoffDelay(attempt, cfg.InitialBackoff, cfg.MaxBackoff)):
+		}
+	}
+	return err
+}
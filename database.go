@@ -0,0 +1,253 @@
+package morondanga
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/rwbm/morondanga/config"
+	"github.com/rwbm/morondanga/pkg/migrations"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"gorm.io/gorm"
+)
+
+// databaseResources are bound to a database connection, and released when
+// it's closed.
+type databaseResources struct {
+	metrics  []metric.Registration
+	replicas *replicaSet
+}
+
+// close unregisters the metrics and closes the replicas.
+func (r *databaseResources) close() error {
+	if r == nil {
+		return nil
+	}
+	for _, reg := range r.metrics {
+		_ = reg.Unregister()
+	}
+	return r.replicas.close()
+}
+
+// configurePool applies the connection pool settings. Negative values mean
+// no limit, as in database/sql.
+func configurePool(sqlDB *sql.DB, dbCfg *config.DatabaseConfig) {
+	if isSQLiteMemory(dbCfg) {
+		// every connection gets its own database, which is gone once closed
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		sqlDB.SetConnMaxIdleTime(0)
+		return
+	}
+	sqlDB.SetMaxOpenConns(dbCfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(dbCfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(dbCfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(dbCfg.ConnMaxIdleTime)
+}
+
+// isSQLiteMemory tells if dbCfg is an in-memory sqlite database.
+func isSQLiteMemory(dbCfg *config.DatabaseConfig) bool {
+	if !strings.EqualFold(strings.TrimSpace(dbCfg.Driver), "sqlite") {
+		return false
+	}
+	dsn := dbCfg.ConnectionString()
+	return strings.HasPrefix(dsn, ":memory:") || strings.Contains(dsn, "mode=memory")
+}
+
+// registerPoolMetrics exports the statistics of the connection pool as
+// observable metrics of the global meter provider, tagged with the pool name.
+// They are reported until the returned registration is unregistered, which
+// must be done before closing the pool.
+func (s *Service) registerPoolMetrics(pool string, sqlDB *sql.DB) (metric.Registration, error) {
+	meter := otel.Meter(s.Configuration().GetApp().Name)
+
+	conns, err := meter.Int64ObservableUpDownCounter("db.client.connection.count",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("Number of connections of the pool, by state (used or idle)"))
+	if err != nil {
+		return nil, err
+	}
+	maxConns, err := meter.Int64ObservableUpDownCounter("db.client.connection.max",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("Maximum number of open connections allowed; 0 means no limit"))
+	if err != nil {
+		return nil, err
+	}
+	waitCount, err := meter.Int64ObservableCounter("db.client.connection.wait.count",
+		metric.WithUnit("{wait}"),
+		metric.WithDescription("Number of times a connection was waited for"))
+	if err != nil {
+		return nil, err
+	}
+	waitDuration, err := meter.Float64ObservableCounter("db.client.connection.wait.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Total time blocked waiting for a connection"))
+	if err != nil {
+		return nil, err
+	}
+
+	poolAttr := attribute.String("db.client.connection.pool.name", pool)
+	used := metric.WithAttributes(poolAttr, attribute.String("db.client.connection.state", "used"))
+	idle := metric.WithAttributes(poolAttr, attribute.String("db.client.connection.state", "idle"))
+	attrs := metric.WithAttributes(poolAttr)
+
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats := sqlDB.Stats()
+		o.ObserveInt64(conns, int64(stats.InUse), used)
+		o.ObserveInt64(conns, int64(stats.Idle), idle)
+		o.ObserveInt64(maxConns, int64(stats.MaxOpenConnections), attrs)
+		o.ObserveInt64(waitCount, stats.WaitCount, attrs)
+		o.ObserveFloat64(waitDuration, stats.WaitDuration.Seconds(), attrs)
+		return nil
+	}, conns, maxConns, waitCount, waitDuration)
+}
+
+// namedDatabase is a connection of the databases section.
+type namedDatabase struct {
+	db  *gorm.DB
+	res *databaseResources
+}
+
+// databaseName returns the name of the module, health check and metrics of
+// a database, e.g. database-legacy, or database for the main one.
+func databaseName(name string) string {
+	if name == "" {
+		return "database"
+	}
+	return "database-" + name
+}
+
+// databaseNames returns the names of the databases section, sorted.
+func (s *Service) databaseNames() []string {
+	names := make([]string, 0, len(s.Configuration().GetDatabases()))
+	for name := range s.Configuration().GetDatabases() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DatabaseNamed returns a database of the databases section, or nil if it's
+// not enabled or not connected yet. Names are matched case-insensitively.
+func (s *Service) DatabaseNamed(name string) *gorm.DB {
+	s.depsMu.RLock()
+	defer s.depsMu.RUnlock()
+	if key, ok := lookupName(s.namedDBs, name); ok && s.namedDBs[key] != nil {
+		return s.namedDBs[key].db
+	}
+	return nil
+}
+
+// lookupName returns the key of m that matches name, exactly or otherwise
+// case-insensitively, since the loader lowercases the names of the databases
+// section. Among several case-insensitive matches, the first sorted one wins.
+func lookupName[V any](m map[string]V, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+	match, found := "", false
+	for k := range m {
+		if strings.EqualFold(k, name) && (!found || k < match) {
+			match, found = k, true
+		}
+	}
+	return match, found
+}
+
+// closeDatabase releases the resources of a database and closes it.
+func closeDatabase(db *gorm.DB, res *databaseResources) error {
+	if db == nil {
+		return nil
+	}
+	var errs []error
+	if err := res.close(); err != nil {
+		errs = append(errs, err)
+	}
+	if sqlDB, err := db.DB(); err != nil {
+		errs = append(errs, fmt.Errorf("database handle: %w", err))
+	} else if err := sqlDB.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("database close: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// pingDatabase checks the connection to a database.
+func pingDatabase(ctx context.Context, db *gorm.DB, dbCfg *config.DatabaseConfig) error {
+	if db == nil {
+		if dbCfg != nil && dbCfg.Enabled {
+			return errors.New("database not connected")
+		}
+		return nil
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("database handle: %w", err)
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Migrator returns a migrator for the migrations given with WithMigrations,
+// to check their status or to migrate up or down, e.g. from a command of the
+// service. The database must be connected.
+func (s *Service) Migrator(opts ...migrations.Option) (*migrations.Migrator, error) {
+	db := s.Database()
+	if db == nil {
+		return nil, errors.New("database not connected")
+	}
+	return s.newMigrator("", db, s.Configuration().GetDatabase(), opts...)
+}
+
+// MigratorNamed is like Migrator, for the migrations of a database of the
+// databases section given with WithNamedMigrations.
+func (s *Service) MigratorNamed(name string, opts ...migrations.Option) (*migrations.Migrator, error) {
+	db := s.DatabaseNamed(name)
+	if db == nil {
+		return nil, fmt.Errorf("database %s not connected", name)
+	}
+	databases := s.Configuration().GetDatabases()
+	key, ok := lookupName(databases, name)
+	if !ok {
+		return nil, fmt.Errorf("database %s not configured", name)
+	}
+	return s.newMigrator(key, db, databases[key], opts...)
+}
+
+func (s *Service) newMigrator(name string, db *gorm.DB, dbCfg *config.DatabaseConfig, opts ...migrations.Option) (*migrations.Migrator, error) {
+	var fsys fs.FS
+	if key, ok := lookupName(s.migrations, name); ok {
+		fsys = s.migrations[key]
+	}
+	if fsys == nil {
+		if name == "" {
+			return nil, errors.New("no migrations given, see WithMigrations")
+		}
+		return nil, fmt.Errorf("no migrations given for database %s, see WithNamedMigrations", name)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("database handle: %w", err)
+	}
+	opts = append([]migrations.Option{migrations.WithLogger(s.Log())}, opts...)
+	return migrations.New(sqlDB, dbCfg.Driver, fsys, opts...)
+}
+
+// migrate applies the pending migrations on startup. It's not bound to the
+// connection timeout, as migrations may take long; the wait for other
+// replicas migrating is bound by the lock timeout instead.
+func (s *Service) migrate(ctx context.Context, name string, db *gorm.DB, dbCfg *config.DatabaseConfig) error {
+	m, err := s.newMigrator(name, db, dbCfg)
+	if err != nil {
+		return fmt.Errorf("migrations: %w", err)
+	}
+	if err := m.Up(context.WithoutCancel(ctx)); err != nil {
+		return fmt.Errorf("migrations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package morondanga
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rwbm/morondanga/config"
+	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// replicaPingTimeout bounds every health check of a replica.
+const replicaPingTimeout = 2 * time.Second
+
+var replicaDialectorFactory = defaultReplicaDialectorFactory
+
+// defaultReplicaDialectorFactory builds the dialector of a replica on top of
+// its pool. Unlike the primary, replicas are not queried on startup, so an
+// unavailable replica doesn't prevent the service from starting.
+func defaultReplicaDialectorFactory(driver string, conn *sql.DB) (gorm.Dialector, error) {
+	switch driver {
+	case "mysql":
+		return mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), nil
+	case "postgres":
+		return postgres.New(postgres.Config{Conn: conn}), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+}
+
+// replica is a read replica and its last known health.
+type replica struct {
+	name string
+	db   *sql.DB
+
+	mu  sync.RWMutex
+	err error
+}
+
+func (r *replica) healthErr() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.err
+}
+
+// replicaSet is the dbresolver policy of a database with read replicas. It
+// routes the reads to the healthy replicas with the configured policy, and
+// to the primary when none is healthy.
+type replicaSet struct {
+	policy   dbresolver.Policy
+	primary  gorm.ConnPool
+	replicas []*replica
+	byPool   map[gorm.ConnPool]*replica
+	log      *zap.Logger
+}
+
+var _ dbresolver.Policy = (*replicaSet)(nil)
+
+func newReplicaPolicy(name string) dbresolver.Policy {
+	if name == "round-robin" {
+		return dbresolver.StrictRoundRobinPolicy()
+	}
+	return dbresolver.RandomPolicy{}
+}
+
+// Resolve picks the pool of a read.
+func (rs *replicaSet) Resolve(pools []gorm.ConnPool) gorm.ConnPool {
+	healthy := make([]gorm.ConnPool, 0, len(pools))
+	for _, pool := range pools {
+		if r, ok := rs.byPool[pool]; !ok || r.healthErr() == nil {
+			healthy = append(healthy, pool)
+		}
+	}
+	switch len(healthy) {
+	case 0:
+		return rs.primary
+	case 1:
+		return healthy[0]
+	}
+	return rs.policy.Resolve(healthy)
+}
+
+// check pings every replica, and takes the ones that fail out of rotation
+// until they answer again.
+func (rs *replicaSet) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range rs.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			pingCtx, cancel := context.WithTimeout(ctx, replicaPingTimeout)
+			err := r.db.PingContext(pingCtx)
+			cancel()
+			if ctx.Err() != nil {
+				// shutting down; keep the last known state
+				return
+			}
+
+			r.mu.Lock()
+			prev := r.err
+			r.err = err
+			r.mu.Unlock()
+
+			switch {
+			case err != nil && prev == nil:
+				rs.log.Warn("Database replica out of rotation", zap.String("replica", r.name), zap.Error(err))
+			case err == nil && prev != nil:
+				rs.log.Info("Database replica back in rotation", zap.String("replica", r.name))
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+// monitor checks the replicas every interval until ctx is done.
+func (rs *replicaSet) monitor(interval time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				rs.check(ctx)
+			}
+		}
+	}
+}
+
+func (rs *replicaSet) close() error {
+	if rs == nil {
+		return nil
+	}
+	var errs []error
+	for _, r := range rs.replicas {
+		if err := r.db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s close: %w", r.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// useReplicas routes the reads of db to the replicas of dbCfg. Writes,
+// transactions and locking reads stay on the primary.
+func (s *Service) useReplicas(db *gorm.DB, dbCfg *config.DatabaseConfig, name string) (*replicaSet, error) {
+	driver := strings.ToLower(strings.TrimSpace(dbCfg.Driver))
+	primary := db.ConnPool
+	if stmtDB, ok := primary.(*gorm.PreparedStmtDB); ok {
+		primary = stmtDB.ConnPool
+	}
+	rs := &replicaSet{
+		policy:  newReplicaPolicy(dbCfg.ReplicaPolicy),
+		primary: primary,
+		byPool:  map[gorm.ConnPool]*replica{},
+		log:     s.Log(),
+	}
+
+	dialectors := make([]gorm.Dialector, 0, len(dbCfg.Replicas))
+	for i, address := range dbCfg.Replicas {
+		replicaCfg := dbCfg.Replica(address)
+		if err := replicaCfg.RegisterTLS(); err != nil {
+			_ = rs.close()
+			return nil, fmt.Errorf("database replica tls: %w", err)
+		}
+		sqlDB, err := s.openSQL(replicaCfg)
+		if err != nil {
+			_ = rs.close()
+			return nil, fmt.Errorf("open database replica: %w", err)
+		}
+		configurePool(sqlDB, replicaCfg)
+
+		r := &replica{name: fmt.Sprintf("%s-replica-%d", name, i+1), db: sqlDB}
+		rs.replicas = append(rs.replicas, r)
+		rs.byPool[sqlDB] = r
+
+		dialector, err := replicaDialectorFactory(driver, sqlDB)
+		if err != nil {
+			_ = rs.close()
+			return nil, err
+		}
+		dialectors = append(dialectors, dialector)
+	}
+
+	// the replicas that are not available yet start out of rotation
+	rs.check(context.Background())
+
+	// dbresolver opens the replicas with the settings of db, which include
+	// the ping; they are checked by the replica set instead
+	disablePing := db.Config.DisableAutomaticPing
+	db.Config.DisableAutomaticPing = true
+	err := db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   rs,
+	}))
+	db.Config.DisableAutomaticPing = disablePing
+	if err != nil {
+		_ = rs.close()
+		return nil, fmt.Errorf("database replicas: %w", err)
+	}
+	return rs, nil
+}
+
+// DatabasePrimary returns the database with every query sent to the primary,
+// e.g. to read data just written, which may not have reached the replicas
+// yet. Without replicas, it's the same as Database. A single query can also
+// be sent to the primary with db.Clauses(dbresolver.Write).
+func (s *Service) DatabasePrimary() *gorm.DB {
+	db := s.Database()
+	if db == nil {
+		return nil
+	}
+	// a new session, so the conditions of a query don't leak into the next
+	return db.Clauses(dbresolver.Write).Session(&gorm.Session{})
+}
@@ -0,0 +1,179 @@
+package morondanga
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/rwbm/morondanga/config"
+	"github.com/rwbm/morondanga/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func TestReplicaSetResolve(t *testing.T) {
+	primary := newTrackedSQLDB(t)
+	r1 := &replica{name: "database-replica-1", db: newTrackedSQLDB(t)}
+	r2 := &replica{name: "database-replica-2", db: newTrackedSQLDB(t)}
+	rs := &replicaSet{
+		policy:   newReplicaPolicy("round-robin"),
+		primary:  primary,
+		replicas: []*replica{r1, r2},
+		byPool:   map[gorm.ConnPool]*replica{r1.db: r1, r2.db: r2},
+		log:      zap.NewNop(),
+	}
+	pools := []gorm.ConnPool{r1.db, r2.db}
+
+	// round-robin over the healthy replicas
+	first, second := rs.Resolve(pools), rs.Resolve(pools)
+	assert.NotSame(t, first, second)
+	assert.ElementsMatch(t, pools, []gorm.ConnPool{first, second})
+
+	r1.err = errors.New("connection refused")
+	for i := 0; i < 4; i++ {
+		assert.Same(t, r2.db, rs.Resolve(pools))
+	}
+
+	// reads fall back to the primary
+	r2.err = errors.New("connection refused")
+	assert.Same(t, primary, rs.Resolve(pools))
+
+	// back in rotation once they answer
+	rs.check(context.Background())
+	assert.NoError(t, r1.healthErr())
+	assert.NoError(t, r2.healthErr())
+}
+
+func TestServiceDatabaseReplicas(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	primary := newTrackedSQLDB(t)
+	originalFactory := dialectorFactory
+	dialectorFactory = func(driver, dsn string) (gorm.Dialector, error) {
+		return stubDialector{name: driver, conn: primary}, nil
+	}
+	originalReplicaFactory := replicaDialectorFactory
+	var replicas []*sql.DB
+	replicaDialectorFactory = func(driver string, conn *sql.DB) (gorm.Dialector, error) {
+		replicas = append(replicas, conn)
+		return stubDialector{name: driver, conn: conn}, nil
+	}
+	t.Cleanup(func() {
+		dialectorFactory = originalFactory
+		replicaDialectorFactory = originalReplicaFactory
+	})
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{
+			Enabled:  true,
+			Driver:   "postgres",
+			Address:  "localhost:5432",
+			Replicas: []string{"127.0.0.1:1"},
+		},
+	}
+	cfg.SetDefaults()
+	s := &Service{cfg: cfg, log: zap.NewNop()}
+	require.NoError(t, s.initDatabase())
+	t.Cleanup(func() { _ = s.stopWorkers(context.Background()) })
+
+	require.Len(t, replicas, 1)
+	require.NotNil(t, s.DatabasePrimary())
+
+	// the replica doesn't answer, so it starts out of rotation
+	rs := s.dbResources.replicas
+	require.Len(t, rs.replicas, 1)
+	assert.Error(t, rs.replicas[0].healthErr())
+	assert.Same(t, primary, rs.Resolve([]gorm.ConnPool{replicas[0]}))
+
+	results := s.CheckHealth(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, "database-replica-1", results[0].Name)
+	assert.Equal(t, "DOWN", results[0].Status)
+	assert.False(t, results[0].Critical)
+
+	require.NoError(t, databaseModule{s}.Stop(context.Background()))
+	assert.Error(t, replicas[0].Ping(), "replicas are closed along with the primary")
+}
+
+func TestServiceDatabaseReplicasRouting(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	// the primary and the replica are different sqlite databases, so the
+	// rows found tell where each query went
+	dir := t.TempDir()
+	primaryPath, replicaPath := filepath.Join(dir, "primary.db"), filepath.Join(dir, "replica.db")
+	for path, names := range map[string][]string{primaryPath: {"a", "b"}, replicaPath: {"replica"}} {
+		db, err := sql.Open("sqlite", path)
+		require.NoError(t, err)
+		_, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)")
+		require.NoError(t, err)
+		for _, name := range names {
+			_, err = db.Exec("INSERT INTO items (name) VALUES (?)", name)
+			require.NoError(t, err)
+		}
+		require.NoError(t, db.Close())
+	}
+
+	originalReplicaFactory := replicaDialectorFactory
+	replicaDialectorFactory = func(driver string, conn *sql.DB) (gorm.Dialector, error) {
+		return &sqlite.Dialector{Conn: conn}, nil
+	}
+	t.Cleanup(func() { replicaDialectorFactory = originalReplicaFactory })
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{
+			Enabled:  true,
+			Driver:   "sqlite",
+			DSN:      primaryPath,
+			Replicas: []string{replicaPath},
+		},
+	}
+	cfg.SetDefaults()
+	s := &Service{cfg: cfg, log: zap.NewNop()}
+	require.NoError(t, s.initDatabase())
+	t.Cleanup(func() {
+		_ = s.stopWorkers(context.Background())
+		_ = databaseModule{s}.Stop(context.Background())
+	})
+
+	type item struct {
+		ID   int
+		Name string
+	}
+	names := func(db *gorm.DB) []string {
+		var out []string
+		require.NoError(t, db.Model(&item{}).Order("id").Pluck("name", &out).Error)
+		return out
+	}
+
+	// reads go to the replica
+	assert.Equal(t, []string{"replica"}, names(s.Database()))
+
+	// writes go to the primary
+	require.NoError(t, s.Database().Create(&item{Name: "c"}).Error)
+	assert.Equal(t, []string{"a", "b", "c"}, names(s.DatabasePrimary()))
+	assert.Equal(t, []string{"replica"}, names(s.Database()))
+
+	// transactions stay on the primary, reads included
+	require.NoError(t, s.Database().Transaction(func(tx *gorm.DB) error {
+		assert.Equal(t, []string{"a", "b", "c"}, names(tx))
+		return tx.Create(&item{Name: "d"}).Error
+	}))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, names(s.DatabasePrimary()))
+
+	// the primary handle can be reused, conditions don't pile up
+	primary := s.DatabasePrimary()
+	var a, b []item
+	require.NoError(t, primary.Where("name = ?", "a").Find(&a).Error)
+	require.NoError(t, primary.Where("name = ?", "b").Find(&b).Error)
+	require.Len(t, a, 1)
+	require.Len(t, b, 1)
+	assert.Equal(t, "b", b[0].Name)
+}
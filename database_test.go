@@ -0,0 +1,345 @@
+package morondanga
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/rwbm/morondanga/config"
+	"github.com/rwbm/morondanga/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func TestServiceDatabasePool(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	reader := sdkmetric.NewManualReader()
+	original := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(original) })
+
+	sqlDB := newTrackedSQLDB(t)
+	originalFactory := dialectorFactory
+	dialectorFactory = func(driver, dsn string) (gorm.Dialector, error) {
+		return stubDialector{name: driver, conn: sqlDB}, nil
+	}
+	t.Cleanup(func() { dialectorFactory = originalFactory })
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{
+			Enabled:         true,
+			Driver:          "postgres",
+			MaxOpenConns:    7,
+			MaxIdleConns:    3,
+			ConnMaxLifetime: time.Minute,
+		},
+	}
+	cfg.SetDefaults()
+	s := &Service{cfg: cfg, log: zap.NewNop()}
+	require.NoError(t, s.initDatabase())
+
+	assert.Equal(t, 7, sqlDB.Stats().MaxOpenConnections)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	got := map[string]bool{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = true
+			if m.Name == "db.client.connection.max" {
+				sum := m.Data.(metricdata.Sum[int64])
+				require.Len(t, sum.DataPoints, 1)
+				assert.EqualValues(t, 7, sum.DataPoints[0].Value)
+				pool, _ := sum.DataPoints[0].Attributes.Value("db.client.connection.pool.name")
+				assert.Equal(t, "database", pool.AsString())
+			}
+		}
+	}
+	for _, name := range []string{
+		"db.client.connection.count",
+		"db.client.connection.max",
+		"db.client.connection.wait.count",
+		"db.client.connection.wait.duration",
+	} {
+		assert.True(t, got[name], name)
+	}
+
+	// the metrics are no longer reported once the pool is closed
+	require.NoError(t, databaseModule{s}.Stop(context.Background()))
+	rm = metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			assert.NotEqual(t, "db.client.connection.max", m.Name)
+		}
+	}
+}
+
+func TestServiceInitDatabaseAutoMigrate(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	sqlDB := newTrackedSQLDB(t)
+	originalFactory := dialectorFactory
+	dialectorFactory = func(driver, dsn string) (gorm.Dialector, error) {
+		return stubDialector{name: driver, conn: sqlDB}, nil
+	}
+	t.Cleanup(func() { dialectorFactory = originalFactory })
+
+	s := &Service{
+		cfg: &config.Config{
+			Database: config.DatabaseConfig{Enabled: true, Driver: "postgres", AutoMigrate: true},
+		},
+		log: zap.NewNop(),
+	}
+	assert.ErrorContains(t, s.initDatabase(), "no migrations given")
+	assert.Nil(t, s.Database())
+
+	_, err := s.Migrator()
+	assert.ErrorContains(t, err, "database not connected")
+}
+
+func TestServiceNamedDatabases(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	conns := map[string]*sql.DB{}
+	originalFactory := dialectorFactory
+	dialectorFactory = func(driver, dsn string) (gorm.Dialector, error) {
+		conns[dsn] = newTrackedSQLDB(t)
+		return stubDialector{name: driver, conn: conns[dsn]}, nil
+	}
+	t.Cleanup(func() { dialectorFactory = originalFactory })
+
+	cfg := &config.Config{
+		Databases: map[string]*config.DatabaseConfig{
+			"reporting": {Enabled: true, Driver: "postgres", DSN: "reporting", MaxOpenConns: 4},
+			"legacy":    {Enabled: true, Driver: "mysql", DSN: "legacy"},
+			"archive":   {Driver: "postgres", DSN: "archive"},
+		},
+	}
+	cfg.SetDefaults()
+	s := &Service{cfg: cfg, log: zap.NewNop()}
+
+	var names []string
+	for _, m := range s.builtinModules() {
+		names = append(names, m.Name())
+		require.NoError(t, m.Init(context.Background(), s))
+	}
+	assert.Equal(t, []string{
+		"observability", "logger", "database",
+		"database-archive", "database-legacy", "database-reporting",
+		"redis",
+	}, names)
+
+	assert.Nil(t, s.Database())
+	assert.Nil(t, s.DatabaseNamed("archive"), "disabled")
+	assert.Nil(t, s.DatabaseNamed("unknown"))
+	require.NotNil(t, s.DatabaseNamed("reporting"))
+	require.NotNil(t, s.DatabaseNamed("legacy"))
+	assert.Equal(t, 4, conns["reporting"].Stats().MaxOpenConnections)
+	assert.Equal(t, config.DefaultDatabaseMaxOpenConns, conns["legacy"].Stats().MaxOpenConnections)
+
+	s.registerBuiltinHealthChecks()
+	results := s.CheckHealth(context.Background())
+	require.Len(t, results, 2)
+	for _, res := range results {
+		assert.Contains(t, []string{"database-legacy", "database-reporting"}, res.Name)
+		assert.Equal(t, "UP", res.Status)
+		assert.True(t, res.Critical)
+	}
+
+	_, err := s.MigratorNamed("reporting")
+	assert.ErrorContains(t, err, "no migrations given for database reporting")
+	_, err = s.MigratorNamed("archive")
+	assert.ErrorContains(t, err, "database archive not connected")
+
+	require.NoError(t, namedDatabaseModule{s, "reporting"}.Stop(context.Background()))
+	assert.Error(t, conns["reporting"].Ping())
+	assert.NoError(t, conns["legacy"].Ping(), "closed on its own")
+	assert.Error(t, namedDatabaseModule{s, "reporting"}.Health(context.Background()))
+}
+
+func TestNewServiceSQLite(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	files := fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+	}
+	s, err := NewService("",
+		WithConfig(&config.Config{
+			HTTP: config.HttpConfig{Address: "127.0.0.1:0"},
+			Database: config.DatabaseConfig{
+				Enabled:     true,
+				Driver:      "sqlite",
+				Database:    ":memory:",
+				AutoMigrate: true,
+			},
+			Databases: map[string]*config.DatabaseConfig{
+				"local": {
+					Enabled:  true,
+					Driver:   "sqlite",
+					Database: filepath.Join(t.TempDir(), "local.db"),
+				},
+			},
+		}),
+		WithLogger(zap.NewNop()),
+		WithMigrations(files),
+		WithNamedMigrations("local", files),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+
+	type user struct {
+		ID   int
+		Name string
+	}
+	require.NoError(t, s.Database().Create(&user{ID: 1, Name: "ana"}).Error)
+	var got user
+	require.NoError(t, s.Database().First(&got, 1).Error)
+	assert.Equal(t, "ana", got.Name)
+
+	// the in-memory database lives in a single connection
+	sqlDB, err := s.Database().DB()
+	require.NoError(t, err)
+	assert.Equal(t, 1, sqlDB.Stats().MaxOpenConnections)
+
+	m, err := s.MigratorNamed("local")
+	require.NoError(t, err)
+	require.NoError(t, m.Up(context.Background()))
+	version, err := m.Version(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, version)
+
+	for _, res := range s.CheckHealth(context.Background()) {
+		assert.Equal(t, "UP", res.Status, res.Name)
+	}
+}
+
+func TestNewServiceNamedDatabaseFromFile(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	// the loader lowercases the keys of the file
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+http:
+  address: "127.0.0.1:0"
+databases:
+  legacyStore:
+    enabled: true
+    driver: "sqlite"
+    database: "`+filepath.Join(dir, "legacy.db")+`"
+    autoMigrate: true
+`), 0o600))
+
+	files := fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+	}
+	s, err := NewService(file, WithLogger(zap.NewNop()), WithNamedMigrations("legacyStore", files))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Shutdown(context.Background()) })
+
+	db := s.DatabaseNamed("legacyStore")
+	require.NotNil(t, db)
+	assert.Same(t, db, s.DatabaseNamed("legacystore"))
+	// migrated on startup
+	require.NoError(t, db.Exec("INSERT INTO users (name) VALUES ('ana')").Error)
+
+	m, err := s.MigratorNamed("legacyStore")
+	require.NoError(t, err)
+	version, err := m.Version(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, version)
+}
+
+func TestServiceDatabaseTracingPerConnection(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	recorder := tracetest.NewSpanRecorder()
+	original := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(original) })
+
+	s := &Service{
+		cfg: &config.Config{Observability: config.ObservabilityConfig{Enabled: true}},
+		log: zap.NewNop(),
+	}
+	query := func(dbCfg *config.DatabaseConfig) int {
+		dbCfg.SetDefaults()
+		db, err := s.openDatabase(context.Background(), dbCfg)
+		require.NoError(t, err)
+		defer func() { _ = closeDatabase(db, nil) }()
+
+		before := len(recorder.Ended())
+		var n int
+		require.NoError(t, db.Raw("SELECT 1").Scan(&n).Error)
+		return len(recorder.Ended()) - before
+	}
+
+	assert.Positive(t, query(&config.DatabaseConfig{Enabled: true, Driver: "sqlite", Database: ":memory:"}))
+	assert.Zero(t, query(&config.DatabaseConfig{Enabled: true, Driver: "sqlite", Database: ":memory:", DisableTracing: true}))
+}
+
+func TestServiceOpenDatabaseSQLiteInstrumented(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	dbCfg := &config.DatabaseConfig{Enabled: true, Driver: "sqlite", Database: ":memory:"}
+	dbCfg.SetDefaults()
+	s := &Service{
+		cfg: &config.Config{Observability: config.ObservabilityConfig{Enabled: true}},
+		log: zap.NewNop(),
+	}
+	db, err := s.openDatabase(context.Background(), dbCfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = closeDatabase(db, nil) })
+
+	var n int
+	require.NoError(t, db.Raw("SELECT 1").Scan(&n).Error)
+	assert.Equal(t, 1, n)
+}
+
+func TestServiceNamedDatabaseRemovedOnReload(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	s, err := NewService("",
+		WithConfig(&config.Config{
+			HTTP: config.HttpConfig{Address: "127.0.0.1:0"},
+			Databases: map[string]*config.DatabaseConfig{
+				"local": {Enabled: true, Driver: "sqlite", Database: ":memory:"},
+			},
+		}),
+		WithLogger(zap.NewNop()),
+	)
+	require.NoError(t, err)
+	sqlDB, err := s.DatabaseNamed("local").DB()
+	require.NoError(t, err)
+
+	// the connection is no longer in the configuration, but it's still open
+	reloaded := &config.Config{HTTP: config.HttpConfig{Address: "127.0.0.1:0"}}
+	reloaded.SetDefaults()
+	s.applyConfig(reloaded)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.ErrorContains(t, sqlDB.Ping(), "database is closed")
+}
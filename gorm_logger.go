@@ -0,0 +1,151 @@
+package morondanga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rwbm/morondanga/config"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+// gormLevels maps the database.logLevel setting to the gorm log levels.
+var gormLevels = map[string]gormlogger.LogLevel{
+	"silent": gormlogger.Silent,
+	"error":  gormlogger.Error,
+	"warn":   gormlogger.Warn,
+	"info":   gormlogger.Info,
+}
+
+// gormLogger writes the gorm logs through the service logger, so they follow
+// the log level and format of the service, and are exported along with the
+// rest of the logs. Failed queries are logged as errors, slow queries as
+// warnings, and the rest of the queries at debug level.
+type gormLogger struct {
+	log           *zap.Logger
+	level         gormlogger.LogLevel
+	slowThreshold time.Duration
+	logParams     bool
+}
+
+var (
+	_ gormlogger.Interface = (*gormLogger)(nil)
+	_ gorm.ParamsFilter    = (*gormLogger)(nil)
+)
+
+func newGormLogger(log *zap.Logger, dbCfg *config.DatabaseConfig) *gormLogger {
+	level, ok := gormLevels[dbCfg.LogLevel]
+	if !ok {
+		// everything, filtered by the level of the service logger
+		level = gormlogger.Info
+	}
+	return &gormLogger{
+		// the caller is always this file; the query location is logged instead
+		log:           log.WithOptions(zap.WithCaller(false)),
+		level:         level,
+		slowThreshold: dbCfg.SlowThreshold,
+		logParams:     dbCfg.LogParams,
+	}
+}
+
+// LogMode returns a copy of the logger with the given level.
+func (l *gormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	out := *l
+	out.level = level
+	return &out
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gormlogger.Info {
+		l.log.Info(fmt.Sprintf(msg, data...), l.messageFields(ctx)...)
+	}
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gormlogger.Warn {
+		l.log.Warn(fmt.Sprintf(msg, data...), l.messageFields(ctx)...)
+	}
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gormlogger.Error {
+		l.log.Error(fmt.Sprintf(msg, data...), l.messageFields(ctx)...)
+	}
+}
+
+func (l *gormLogger) messageFields(ctx context.Context) []zap.Field {
+	return append([]zap.Field{zap.String("source", utils.FileWithLineNum())}, traceFields(ctx)...)
+}
+
+// Trace logs a query once it's done.
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	var lvl zapcore.Level
+	var msg string
+	switch {
+	case err != nil && l.level >= gormlogger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		lvl, msg = zapcore.ErrorLevel, "Database query failed"
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= gormlogger.Warn:
+		lvl, msg = zapcore.WarnLevel, "Slow database query"
+	case l.level >= gormlogger.Info:
+		lvl, msg = zapcore.DebugLevel, "Database query"
+	default:
+		return
+	}
+
+	ce := l.log.Check(lvl, msg)
+	if ce == nil {
+		return
+	}
+	sql, rows := fc()
+	fields := []zap.Field{
+		zap.String("sql", sql),
+		zap.Duration("duration", elapsed),
+		zap.String("source", utils.FileWithLineNum()),
+	}
+	if rows >= 0 {
+		fields = append(fields, zap.Int64("rows", rows))
+	}
+	if lvl == zapcore.ErrorLevel {
+		fields = append(fields, zap.Error(err))
+	}
+	if lvl == zapcore.WarnLevel {
+		fields = append(fields, zap.Duration("slow_threshold", l.slowThreshold))
+	}
+	ce.Write(append(fields, traceFields(ctx)...)...)
+}
+
+// ParamsFilter leaves the placeholders in the logged queries, instead of the
+// parameters, unless database.logParams is set.
+func (l *gormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if l.logParams {
+		return sql, params
+	}
+	return sql, nil
+}
+
+// traceFields returns the identifiers of the span in ctx, if any, to
+// correlate the logs with the traces.
+func traceFields(ctx context.Context) []zap.Field {
+	if ctx == nil {
+		return nil
+	}
+	sc := trace.SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return nil
+	}
+	return []zap.Field{
+		zap.String("trace_id", sc.TraceID().String()),
+		zap.String("span_id", sc.SpanID().String()),
+	}
+}
@@ -0,0 +1,94 @@
+package morondanga
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rwbm/morondanga/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestGormLoggerTrace(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := newGormLogger(zap.New(core), &config.DatabaseConfig{SlowThreshold: 100 * time.Millisecond})
+
+	traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := trace.SpanIDFromHex("0102030405060708")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+	query := func() (string, int64) { return "SELECT * FROM users WHERE id = ?", 1 }
+
+	l.Trace(ctx, time.Now(), query, nil)
+	l.Trace(ctx, time.Now().Add(-time.Second), query, nil)
+	l.Trace(ctx, time.Now(), query, errors.New("connection reset"))
+	l.Trace(ctx, time.Now(), query, gorm.ErrRecordNotFound)
+
+	entries := logs.All()
+	require.Len(t, entries, 4)
+
+	assert.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	assert.Equal(t, "Database query", entries[0].Message)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", fields["sql"])
+	assert.EqualValues(t, 1, fields["rows"])
+	assert.Contains(t, fields, "duration")
+	assert.Equal(t, traceID.String(), fields["trace_id"])
+	assert.Equal(t, spanID.String(), fields["span_id"])
+
+	assert.Equal(t, zapcore.WarnLevel, entries[1].Level)
+	assert.Equal(t, "Slow database query", entries[1].Message)
+
+	assert.Equal(t, zapcore.ErrorLevel, entries[2].Level)
+	assert.Equal(t, "connection reset", entries[2].ContextMap()["error"])
+
+	// record not found is not an error worth logging
+	assert.Equal(t, zapcore.DebugLevel, entries[3].Level)
+}
+
+func TestGormLoggerLevels(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := newGormLogger(zap.New(core), &config.DatabaseConfig{LogLevel: "warn", SlowThreshold: -1})
+	query := func() (string, int64) { return "SELECT 1", -1 }
+
+	l.Trace(context.Background(), time.Now().Add(-time.Hour), query, nil)
+	assert.Zero(t, logs.Len(), "no queries at warn level, and slow queries disabled")
+
+	l.Trace(context.Background(), time.Now(), query, errors.New("boom"))
+	require.Equal(t, 1, logs.Len())
+	assert.NotContains(t, logs.All()[0].ContextMap(), "rows")
+
+	silent := l.LogMode(gormlogger.Silent)
+	silent.Trace(context.Background(), time.Now(), query, errors.New("boom"))
+	silent.Error(context.Background(), "failed: %s", "boom")
+	assert.Equal(t, 1, logs.Len())
+
+	// the level of the service logger applies on top
+	core, logs = observer.New(zapcore.InfoLevel)
+	l = newGormLogger(zap.New(core), &config.DatabaseConfig{})
+	l.Trace(context.Background(), time.Now(), query, nil)
+	l.Info(context.Background(), "ready in %s", "1s")
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, "ready in 1s", logs.All()[0].Message)
+}
+
+func TestGormLoggerParamsFilter(t *testing.T) {
+	l := newGormLogger(zap.NewNop(), &config.DatabaseConfig{})
+	sql, params := l.ParamsFilter(context.Background(), "SELECT * FROM users WHERE email = ?", "jane@example.com")
+	assert.Equal(t, "SELECT * FROM users WHERE email = ?", sql)
+	assert.Empty(t, params)
+
+	l = newGormLogger(zap.NewNop(), &config.DatabaseConfig{LogParams: true})
+	_, params = l.ParamsFilter(context.Background(), "SELECT * FROM users WHERE email = ?", "jane@example.com")
+	assert.Equal(t, []interface{}{"jane@example.com"}, params)
+}
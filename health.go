@@ -0,0 +1,206 @@
+package morondanga
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	healthStatusOK           = "OK"
+	healthStatusDegraded     = "DEGRADED"
+	healthStatusDown         = "DOWN"
+	healthStatusShuttingDown = "SHUTTING_DOWN"
+
+	healthCheckUp   = "UP"
+	healthCheckDown = "DOWN"
+)
+
+// HealthCheckFunc returns a non-nil error when the checked dependency is not healthy.
+type HealthCheckFunc func(ctx context.Context) error
+
+// HealthCheckResult is the last known result of a health check.
+type HealthCheckResult struct {
+	Name        string
+	Status      string
+	Critical    bool
+	Latency     string
+	Error       string     `json:",omitempty"`
+	LastError   string     `json:",omitempty"`
+	LastErrorAt *time.Time `json:",omitempty"`
+	CheckedAt   time.Time
+}
+
+type healthCheck struct {
+	name     string
+	fn       HealthCheckFunc
+	critical bool
+
+	mu     sync.Mutex
+	result HealthCheckResult
+}
+
+// run returns the cached result if it's younger than ttl, or runs the check
+// otherwise. Concurrent callers wait for the same run instead of hitting the
+// dependency again.
+func (hc *healthCheck) run(ctx context.Context, ttl, timeout time.Duration) HealthCheckResult {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if !hc.result.CheckedAt.IsZero() && time.Since(hc.result.CheckedAt) < ttl {
+		return hc.result
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := hc.fn(ctx)
+
+	hc.result.Name = hc.name
+	hc.result.Critical = hc.critical
+	hc.result.Latency = time.Since(start).String()
+	hc.result.CheckedAt = start
+	hc.result.Status = healthCheckUp
+	hc.result.Error = ""
+	if err != nil {
+		hc.result.Status = healthCheckDown
+		hc.result.Error = err.Error()
+		hc.result.LastError = err.Error()
+		hc.result.LastErrorAt = &start
+	}
+	return hc.result
+}
+
+// AddHealthCheck registers a health check reported by the /health endpoint.
+// When a critical check fails, the service is reported as not ready by /readyz;
+// non-critical failures only degrade the /health status.
+//
+// Results are cached for HttpConfig.HealthCacheTTL, so probes don't hammer
+// the dependencies. Registering a check with an existing name replaces it.
+func (s *Service) AddHealthCheck(name string, fn HealthCheckFunc, critical bool) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	hc := &healthCheck{name: name, fn: fn, critical: critical}
+	for i, existing := range s.healthChecks {
+		if existing.name == name {
+			s.healthChecks[i] = hc
+			return
+		}
+	}
+	s.healthChecks = append(s.healthChecks, hc)
+}
+
+// CheckHealth runs all the registered health checks concurrently (or takes their
+// cached results), and returns them sorted by name.
+func (s *Service) CheckHealth(ctx context.Context) []HealthCheckResult {
+	s.healthMu.Lock()
+	checks := make([]*healthCheck, len(s.healthChecks))
+	copy(checks, s.healthChecks)
+	s.healthMu.Unlock()
+
+	httpCfg := s.Configuration().GetHTTP()
+	results := make([]HealthCheckResult, len(checks))
+
+	var wg sync.WaitGroup
+	for i, hc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = hc.run(ctx, httpCfg.HealthCacheTTL, httpCfg.HealthTimeout)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+// healthStatus summarizes the check results and workers into the overall status,
+// and tells if the service is ready to receive traffic.
+func (s *Service) healthStatus(results []HealthCheckResult, workers []WorkerStatus) (string, bool) {
+	if !s.Ready() {
+		return healthStatusShuttingDown, false
+	}
+
+	status := healthStatusOK
+	for _, r := range results {
+		if r.Status == healthCheckUp {
+			continue
+		}
+		if r.Critical {
+			return healthStatusDown, false
+		}
+		status = healthStatusDegraded
+	}
+	for _, w := range workers {
+		if w.State == WorkerFailed {
+			status = healthStatusDegraded
+		}
+	}
+	return status, true
+}
+
+// registerBuiltinHealthChecks adds the checks for the enabled (or injected)
+// built-in integrations.
+func (s *Service) registerBuiltinHealthChecks() {
+	if s.Configuration().GetDatabase().Enabled || s.Database() != nil {
+		s.AddHealthCheck("database", databaseModule{s}.Health, true)
+	}
+	for _, m := range s.namedDatabaseModules() {
+		if dbCfg := s.Configuration().GetDatabases()[m.name]; (dbCfg != nil && dbCfg.Enabled) || s.DatabaseNamed(m.name) != nil {
+			s.AddHealthCheck(m.Name(), m.Health, true)
+		}
+	}
+	if s.Configuration().GetRedis().Enabled || s.Redis() != nil {
+		s.AddHealthCheck("redis", redisModule{s}.Health, true)
+	}
+}
+
+// setHealthCheck registers the built-in health endpoints in e:
+//   - /livez reports that the process is alive, without checking any dependency;
+//   - /readyz fails while shutting down or when a critical check fails;
+//   - /health returns the detailed status of every check and worker.
+func (s *Service) setHealthCheck(e *echo.Echo) {
+	type statusResponse struct {
+		Status string
+	}
+	type healthResponse struct {
+		Status  string
+		Checks  []HealthCheckResult `json:",omitempty"`
+		Workers []WorkerStatus      `json:",omitempty"`
+	}
+
+	e.GET("/livez", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, statusResponse{Status: healthStatusOK})
+	})
+
+	e.GET("/readyz", func(c echo.Context) error {
+		status, ready := s.healthStatus(s.CheckHealth(c.Request().Context()), nil)
+		if !ready {
+			return c.JSON(http.StatusServiceUnavailable, statusResponse{Status: status})
+		}
+		return c.JSON(http.StatusOK, statusResponse{Status: status})
+	})
+
+	e.GET("/health", func(c echo.Context) error {
+		resp := healthResponse{
+			Checks:  s.CheckHealth(c.Request().Context()),
+			Workers: s.Workers(),
+		}
+		status, ready := s.healthStatus(resp.Checks, resp.Workers)
+		resp.Status = status
+		if !ready {
+			return c.JSON(http.StatusServiceUnavailable, resp)
+		}
+		return c.JSON(http.StatusOK, resp)
+	})
+}
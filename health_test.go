@@ -0,0 +1,115 @@
+package morondanga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rwbm/morondanga/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newHealthTestService(ttl time.Duration) *Service {
+	s := &Service{
+		server: echo.New(),
+		cfg: &config.Config{
+			HTTP: config.HttpConfig{
+				HealthCacheTTL: ttl,
+				HealthTimeout:  time.Second,
+			},
+		},
+		log: zap.NewNop(),
+	}
+	s.setHealthCheck(s.server)
+	return s
+}
+
+func doHealthRequest(t *testing.T, s *Service, path string) (int, map[string]interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return rec.Code, body
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	s := newHealthTestService(0)
+
+	var cacheDown atomic.Bool
+	s.AddHealthCheck("database", func(ctx context.Context) error { return nil }, true)
+	s.AddHealthCheck("cache", func(ctx context.Context) error {
+		if cacheDown.Load() {
+			return errors.New("cache unreachable")
+		}
+		return nil
+	}, false)
+
+	code, body := doHealthRequest(t, s, "/health")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "OK", body["Status"])
+	assert.Len(t, body["Checks"], 2)
+
+	// non-critical failures only degrade the service
+	cacheDown.Store(true)
+	code, body = doHealthRequest(t, s, "/health")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "DEGRADED", body["Status"])
+	check := body["Checks"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "cache", check["Name"])
+	assert.Equal(t, "DOWN", check["Status"])
+	assert.Equal(t, "cache unreachable", check["Error"])
+
+	code, _ = doHealthRequest(t, s, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	// critical failures make the service not ready, but still alive
+	s.AddHealthCheck("database", func(ctx context.Context) error { return errors.New("db down") }, true)
+	code, body = doHealthRequest(t, s, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "DOWN", body["Status"])
+
+	code, _ = doHealthRequest(t, s, "/livez")
+	assert.Equal(t, http.StatusOK, code)
+
+	// the last error is kept after recovering
+	cacheDown.Store(false)
+	_, body = doHealthRequest(t, s, "/health")
+	check = body["Checks"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "UP", check["Status"])
+	assert.Equal(t, "cache unreachable", check["LastError"])
+}
+
+func TestHealthChecksAreCached(t *testing.T) {
+	s := newHealthTestService(time.Minute)
+
+	var calls int32
+	s.AddHealthCheck("database", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}, true)
+
+	for i := 0; i < 5; i++ {
+		code, _ := doHealthRequest(t, s, "/readyz")
+		assert.Equal(t, http.StatusOK, code)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestHealthReportsShuttingDown(t *testing.T) {
+	s := newHealthTestService(0)
+	s.draining.Store(true)
+
+	code, body := doHealthRequest(t, s, "/health")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "SHUTTING_DOWN", body["Status"])
+}
@@ -0,0 +1,167 @@
+package morondanga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	unixAddressPrefix = "unix://"
+
+	// systemd passes the activated sockets starting at this file descriptor
+	systemdListenFdsStart = 3
+)
+
+// listeners are looked up by name when inherited from systemd, and by
+// position when the sockets are not named
+const (
+	listenerHTTP  = "http"
+	listenerAdmin = "admin"
+)
+
+var (
+	systemdOnce      sync.Once
+	systemdNames     []string
+	systemdListeners []net.Listener
+	systemdErr       error
+)
+
+// listen creates the listener for a server. In order of preference, it's:
+//   - a socket inherited through systemd socket activation (LISTEN_FDS);
+//   - a Unix socket, if the address is unix:///path/to/socket;
+//   - a TCP socket, with SO_REUSEPORT if HttpConfig.ReusePort is set.
+func (s *Service) listen(name, address string) (net.Listener, error) {
+	ln, err := inheritedListener(name)
+	if err != nil {
+		return nil, err
+	}
+	if ln != nil {
+		return ln, nil
+	}
+
+	httpCfg := s.Configuration().GetHTTP()
+
+	if path, ok := strings.CutPrefix(address, unixAddressPrefix); ok {
+		return listenUnix(path, httpCfg.SocketMode)
+	}
+
+	lc := net.ListenConfig{}
+	if httpCfg.ReusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", address)
+}
+
+// listenUnix listens on a Unix socket, removing a stale socket file left by a
+// previous process, and sets the socket file mode if given (in octal).
+func listenUnix(path, mode string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("empty unix socket path")
+	}
+
+	var perm fs.FileMode
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid socket mode %q: %w", mode, err)
+		}
+		perm = fs.FileMode(m)
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("unix socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale unix socket: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != "" {
+		if err := os.Chmod(path, perm); err != nil {
+			_ = ln.Close()
+			return nil, fmt.Errorf("set unix socket mode: %w", err)
+		}
+	}
+	return ln, nil
+}
+
+// inheritedListener returns the socket passed by systemd for the given listener
+// name, or nil if the process was not socket activated. Named sockets
+// (FileDescriptorName=) are matched by name; otherwise, the HTTP server takes
+// the first one and the admin server the second one.
+func inheritedListener(name string) (net.Listener, error) {
+	systemdOnce.Do(func() {
+		systemdNames, systemdListeners, systemdErr = systemdActivationListeners()
+	})
+	if systemdErr != nil {
+		return nil, fmt.Errorf("systemd socket activation: %w", systemdErr)
+	}
+
+	for i, n := range systemdNames {
+		if n == name {
+			return systemdListeners[i], nil
+		}
+	}
+
+	index := 0
+	if name == listenerAdmin {
+		index = 1
+	}
+	if index < len(systemdListeners) {
+		return systemdListeners[index], nil
+	}
+	return nil, nil
+}
+
+// systemdActivationListeners turns the file descriptors passed by systemd into
+// listeners, following sd_listen_fds(3). The environment variables are unset,
+// so they're not inherited by child processes.
+func systemdActivationListeners() ([]string, []net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil, nil
+	}
+
+	var names []string
+	if v := os.Getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := systemdListenFdsStart; fd < systemdListenFdsStart+count; fd++ {
+		f := os.NewFile(uintptr(fd), "systemd-listener-"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, nil, fmt.Errorf("fd %d: %w", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return names, listeners, nil
+}
@@ -0,0 +1,80 @@
+package morondanga
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rwbm/morondanga/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServiceListensOnUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	// a stale socket file is replaced
+	stale, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	s := &Service{
+		server: echo.New(),
+		cfg: &config.Config{
+			HTTP: config.HttpConfig{
+				Address:    "unix://" + socket,
+				SocketMode: "0600",
+			},
+		},
+		log: zap.NewNop(),
+	}
+	s.GET("/hello", func(c echo.Context) error { return c.String(http.StatusOK, "hello") })
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run()
+	}()
+	// Allow server to start.
+	time.Sleep(100 * time.Millisecond)
+
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	res, err := client.Get("http://unix/hello")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "hello", string(body))
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-errCh, http.ErrServerClosed)
+}
+
+func TestServiceListenReusePort(t *testing.T) {
+	s := &Service{
+		cfg: &config.Config{
+			HTTP: config.HttpConfig{ReusePort: true},
+		},
+	}
+
+	first, err := s.listen(listenerHTTP, "127.0.0.1:0")
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := s.listen(listenerHTTP, first.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+}
@@ -14,6 +14,9 @@ type contextKey string
 var (
 	loggerMu sync.RWMutex
 	logger   *zap.Logger
+	// level is shared by all the loggers built by this package,
+	// so it can be changed at runtime
+	level    = zap.NewAtomicLevelAt(zapcore.InfoLevel)
 	cfgCache = struct {
 		level  int
 		format string
@@ -43,6 +46,13 @@ func Get() *zap.Logger {
 	return logger
 }
 
+// AtomicLevel returns the level shared by the loggers built by this package.
+// Changing it affects them at runtime; it also implements http.Handler, to
+// get and set the level as JSON.
+func AtomicLevel() zap.AtomicLevel {
+	return level
+}
+
 // OTELOptions controls the optional OTEL zap bridge.
 type OTELOptions struct {
 	Enabled     bool
@@ -165,13 +175,15 @@ func (c noCallerCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
 	return c.Core.Write(entry, fields)
 }
 
-func configLogger(level int, format string) *zap.Logger {
+func configLogger(lvl int, format string) *zap.Logger {
+	level.SetLevel(zapcore.Level(lvl))
+
 	encoderCfg := zap.NewProductionEncoderConfig()
 	encoderCfg.TimeKey = "timestamp"
 	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
 
 	config := zap.Config{
-		Level:             zap.NewAtomicLevelAt(zapcore.Level(level)),
+		Level:             level,
 		Development:       false,
 		DisableCaller:     false,
 		DisableStacktrace: false,
@@ -12,6 +12,7 @@ func ResetForTests() {
 	logger = nil
 	cfgCache.level = int(zapcore.InfoLevel)
 	cfgCache.format = "json"
+	level.SetLevel(zapcore.InfoLevel)
 }
 
 // OverrideLoggerForTests sets the global logger to the provided instance.
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"crypto/x509"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	clientCertContextKey        = "client_cert"
+	clientCertSubjectContextKey = "client_cert_subject"
+)
+
+// ClientCert returns middleware that stores the verified TLS client certificate,
+// and its subject, in the echo context. Requests without a verified certificate
+// are passed through untouched.
+func ClientCert() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if state := c.Request().TLS; state != nil && len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+				cert := state.VerifiedChains[0][0]
+				c.Set(clientCertContextKey, cert)
+				c.Set(clientCertSubjectContextKey, cert.Subject.String())
+			}
+			return next(c)
+		}
+	}
+}
+
+// ClientCertificate retrieves the verified client certificate stored by ClientCert middleware.
+func ClientCertificate(c echo.Context) (*x509.Certificate, bool) {
+	cert, ok := c.Get(clientCertContextKey).(*x509.Certificate)
+	return cert, ok && cert != nil
+}
+
+// ClientCertSubject retrieves the subject of the verified client certificate
+// stored by ClientCert middleware, e.g. "CN=orders,O=acme".
+func ClientCertSubject(c echo.Context) (string, bool) {
+	subject, ok := c.Get(clientCertSubjectContextKey).(string)
+	return subject, ok && subject != ""
+}
@@ -0,0 +1,198 @@
+package morondanga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultModuleStopTimeout is the time a module is given to stop during Shutdown,
+// unless it implements ModuleStopTimeout.
+var DefaultModuleStopTimeout = 10 * time.Second
+
+// Module is a component whose lifecycle is managed by the Service, like the
+// built-in database and redis integrations, or a Kafka consumer or a cache.
+type Module interface {
+	// Name identifies the module in logs, errors and health reports.
+	// It must be unique within the service.
+	Name() string
+	// Init prepares the module. It's called once, when the module is registered.
+	Init(ctx context.Context, s *Service) error
+	// Start is called by Run, in registration order, before the HTTP server
+	// starts accepting requests.
+	Start(ctx context.Context) error
+	// Stop releases the module resources. It's called by Shutdown in reverse
+	// registration order, even if Start was never called.
+	Stop(ctx context.Context) error
+	// Health returns a non-nil error if the module is not working properly.
+	Health(ctx context.Context) error
+}
+
+// ModuleStopTimeout can be implemented by a Module that needs a different
+// stop timeout than DefaultModuleStopTimeout.
+type ModuleStopTimeout interface {
+	StopTimeout() time.Duration
+}
+
+// ModuleCritical can be implemented by a Module to choose whether its health
+// is a critical health check. Modules are critical unless Critical returns
+// false, e.g. for an optional consumer whose failure should only degrade the
+// service instead of taking it out of rotation.
+type ModuleCritical interface {
+	Critical() bool
+}
+
+// RegisterModule initializes the module and adds it to the service lifecycle.
+// Modules registered after Run was called are started right away.
+// The module health is reported as a critical health check, unless the module
+// implements ModuleCritical.
+//
+// Modules are always started after the built-in ones (observability, logger,
+// database and redis), and stopped before them.
+func (s *Service) RegisterModule(m Module) error {
+	if m == nil {
+		return errors.New("the module cannot be nil")
+	}
+
+	s.modulesMu.Lock()
+	for _, existing := range s.lifecycle() {
+		if existing.Name() == m.Name() {
+			s.modulesMu.Unlock()
+			return fmt.Errorf("module %s is already registered", m.Name())
+		}
+	}
+	s.modulesMu.Unlock()
+
+	ctx := context.Background()
+	if err := m.Init(ctx, s); err != nil {
+		return fmt.Errorf("init %s module: %w", m.Name(), err)
+	}
+
+	s.modulesMu.Lock()
+	defer s.modulesMu.Unlock()
+
+	if s.modulesStarted {
+		if err := m.Start(ctx); err != nil {
+			_ = stopModule(ctx, m)
+			return fmt.Errorf("start %s module: %w", m.Name(), err)
+		}
+	}
+
+	s.modules = append(s.modules, m)
+	s.AddHealthCheck(m.Name(), m.Health, moduleCritical(m))
+	return nil
+}
+
+// moduleCritical reports whether the health of m is a critical health check.
+func moduleCritical(m Module) bool {
+	if c, ok := m.(ModuleCritical); ok {
+		return c.Critical()
+	}
+	return true
+}
+
+// Modules returns the modules managed by the service, in start order.
+func (s *Service) Modules() []Module {
+	s.modulesMu.Lock()
+	defer s.modulesMu.Unlock()
+	return s.lifecycle()
+}
+
+// lifecycle returns the built-in modules followed by the registered ones.
+func (s *Service) lifecycle() []Module {
+	builtins := s.builtins
+	if builtins == nil {
+		// not initialized, e.g. a service built in tests
+		builtins = s.builtinModules()
+	}
+	return append(slices.Clone(builtins), s.modules...)
+}
+
+// initBuiltinModules initializes the built-in modules in dependency order.
+// If one of them fails, the ones already initialized are stopped.
+func (s *Service) initBuiltinModules(ctx context.Context) error {
+	builtins := s.builtinModules()
+	s.modulesMu.Lock()
+	s.builtins = builtins
+	s.modulesMu.Unlock()
+	for i, m := range builtins {
+		if err := m.Init(ctx, s); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				_ = stopModule(ctx, builtins[j])
+			}
+			return fmt.Errorf("init %s: %w", m.Name(), err)
+		}
+	}
+	return nil
+}
+
+// startModules starts all the modules, only once.
+// If one of them fails, the ones already started are stopped in reverse order.
+func (s *Service) startModules(ctx context.Context) error {
+	s.modulesMu.Lock()
+	defer s.modulesMu.Unlock()
+
+	if s.modulesStarted {
+		return nil
+	}
+	modules := s.lifecycle()
+	for i, m := range modules {
+		if err := m.Start(ctx); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				_ = stopModule(ctx, modules[j])
+			}
+			return fmt.Errorf("start %s module: %w", m.Name(), err)
+		}
+	}
+	s.modulesStarted = true
+	return nil
+}
+
+// stopModules stops all the modules in reverse order, each one bounded by its
+// own timeout, and returns every error found along the way.
+func (s *Service) stopModules(ctx context.Context) []error {
+	s.modulesMu.Lock()
+	defer s.modulesMu.Unlock()
+
+	var errs []error
+	modules := s.lifecycle()
+	for i := len(modules) - 1; i >= 0; i-- {
+		m := modules[i]
+		if err := stopModule(ctx, m); err != nil {
+			errs = append(errs, fmt.Errorf("stop %s module: %w", m.Name(), err))
+			if s.log != nil {
+				s.log.Error("Failed to stop module", zap.String("module", m.Name()), zap.Error(err))
+			}
+		}
+	}
+	s.modulesStarted = false
+	return errs
+}
+
+// stopModule calls m.Stop and waits for it at most for the module stop timeout,
+// so a module that hangs can't prevent the others from stopping.
+func stopModule(ctx context.Context, m Module) error {
+	timeout := DefaultModuleStopTimeout
+	if t, ok := m.(ModuleStopTimeout); ok && t.StopTimeout() > 0 {
+		timeout = t.StopTimeout()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Stop(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,195 @@
+package morondanga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rwbm/morondanga/logging"
+)
+
+// builtinModules returns the modules backing the built-in integrations, in
+// dependency order. They're no-ops when the integration is disabled.
+func (s *Service) builtinModules() []Module {
+	modules := []Module{
+		observabilityModule{s},
+		loggerModule{s},
+		databaseModule{s},
+	}
+	for _, name := range s.databaseNames() {
+		modules = append(modules, namedDatabaseModule{s, name})
+	}
+	return append(modules, redisModule{s})
+}
+
+// observabilityModule sets up the OTEL providers. It must be initialized before
+// the logger, so the zap bridge is active.
+type observabilityModule struct {
+	s *Service
+}
+
+func (m observabilityModule) Name() string { return "observability" }
+
+func (m observabilityModule) Init(ctx context.Context, s *Service) error {
+	return s.initObservability()
+}
+
+func (m observabilityModule) Start(ctx context.Context) error { return nil }
+
+func (m observabilityModule) Stop(ctx context.Context) error {
+	if m.s.otelShutdown != nil {
+		m.s.otelShutdown()
+	}
+	return nil
+}
+
+func (m observabilityModule) Health(ctx context.Context) error { return nil }
+
+// loggerModule sets the service logger, with the optional otelzap bridge when
+// observability is enabled.
+type loggerModule struct {
+	s *Service
+}
+
+func (m loggerModule) Name() string { return "logger" }
+
+func (m loggerModule) Init(ctx context.Context, s *Service) error {
+	if s.log != nil {
+		// injected with WithLogger
+		return nil
+	}
+	obs := s.Configuration().GetObservability()
+	s.log = logging.GetWithConfig(
+		s.Configuration().GetApp().LogLevel,
+		s.Configuration().GetApp().LogFormat,
+		logging.OTELOptions{
+			Enabled:     obs != nil && obs.Enabled,
+			ServiceName: s.Configuration().GetApp().Name,
+		})
+	return nil
+}
+
+func (m loggerModule) Start(ctx context.Context) error { return nil }
+
+func (m loggerModule) Stop(ctx context.Context) error {
+	if m.s.log != nil {
+		// syncing stderr fails on some platforms; nothing to do about it
+		_ = m.s.log.Sync()
+	}
+	return nil
+}
+
+func (m loggerModule) Health(ctx context.Context) error { return nil }
+
+// databaseModule manages the gorm connection.
+type databaseModule struct {
+	s *Service
+}
+
+func (m databaseModule) Name() string { return "database" }
+
+func (m databaseModule) Init(ctx context.Context, s *Service) error {
+	if !s.Configuration().GetDatabase().Enabled || s.Database() != nil {
+		return nil
+	}
+	return s.initDatabase()
+}
+
+func (m databaseModule) Start(ctx context.Context) error { return nil }
+
+func (m databaseModule) Stop(ctx context.Context) error {
+	m.s.depsMu.RLock()
+	db, res := m.s.db, m.s.dbResources
+	m.s.depsMu.RUnlock()
+	return closeDatabase(db, res)
+}
+
+func (m databaseModule) Health(ctx context.Context) error {
+	return pingDatabase(ctx, m.s.Database(), m.s.Configuration().GetDatabase())
+}
+
+// namedDatabaseModules returns the modules of the databases section, as of
+// init.
+func (s *Service) namedDatabaseModules() []namedDatabaseModule {
+	s.modulesMu.Lock()
+	modules := s.lifecycle()
+	s.modulesMu.Unlock()
+
+	var named []namedDatabaseModule
+	for _, m := range modules {
+		if m, ok := m.(namedDatabaseModule); ok {
+			named = append(named, m)
+		}
+	}
+	return named
+}
+
+// namedDatabaseModule manages a connection of the databases section.
+type namedDatabaseModule struct {
+	s    *Service
+	name string
+}
+
+func (m namedDatabaseModule) Name() string { return databaseName(m.name) }
+
+func (m namedDatabaseModule) Init(ctx context.Context, s *Service) error {
+	dbCfg := s.Configuration().GetDatabases()[m.name]
+	if dbCfg == nil || !dbCfg.Enabled || s.DatabaseNamed(m.name) != nil {
+		return nil
+	}
+	return s.initNamedDatabase(m.name, dbCfg)
+}
+
+func (m namedDatabaseModule) Start(ctx context.Context) error { return nil }
+
+func (m namedDatabaseModule) Stop(ctx context.Context) error {
+	m.s.depsMu.RLock()
+	named := m.s.namedDBs[m.name]
+	m.s.depsMu.RUnlock()
+	if named == nil {
+		return nil
+	}
+	return closeDatabase(named.db, named.res)
+}
+
+func (m namedDatabaseModule) Health(ctx context.Context) error {
+	return pingDatabase(ctx, m.s.DatabaseNamed(m.name), m.s.Configuration().GetDatabases()[m.name])
+}
+
+// redisModule manages the redis client.
+type redisModule struct {
+	s *Service
+}
+
+func (m redisModule) Name() string { return "redis" }
+
+func (m redisModule) Init(ctx context.Context, s *Service) error {
+	if !s.Configuration().GetRedis().Enabled || s.Redis() != nil {
+		return nil
+	}
+	return s.initRedis()
+}
+
+func (m redisModule) Start(ctx context.Context) error { return nil }
+
+func (m redisModule) Stop(ctx context.Context) error {
+	cli := m.s.Redis()
+	if cli == nil {
+		return nil
+	}
+	if err := cli.Close(); err != nil {
+		return fmt.Errorf("redis close: %w", err)
+	}
+	return nil
+}
+
+func (m redisModule) Health(ctx context.Context) error {
+	cli := m.s.Redis()
+	if cli == nil {
+		if m.s.Configuration().GetRedis().Enabled {
+			return errors.New("redis not connected")
+		}
+		return nil
+	}
+	return cli.Ping(ctx)
+}
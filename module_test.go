@@ -0,0 +1,160 @@
+package morondanga
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rwbm/morondanga/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type recordingModule struct {
+	name     string
+	events   *[]string
+	mu       *sync.Mutex
+	startErr error
+	stopErr  error
+	block    bool
+	timeout  time.Duration
+	optional bool
+}
+
+func (m *recordingModule) record(event string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*m.events = append(*m.events, m.name+":"+event)
+}
+
+func (m *recordingModule) Name() string { return m.name }
+
+func (m *recordingModule) Init(ctx context.Context, s *Service) error {
+	m.record("init")
+	return nil
+}
+
+func (m *recordingModule) Start(ctx context.Context) error {
+	m.record("start")
+	return m.startErr
+}
+
+func (m *recordingModule) Stop(ctx context.Context) error {
+	if m.block {
+		<-make(chan struct{})
+	}
+	m.record("stop")
+	return m.stopErr
+}
+
+func (m *recordingModule) Health(ctx context.Context) error { return nil }
+
+func (m *recordingModule) StopTimeout() time.Duration { return m.timeout }
+
+func (m *recordingModule) Critical() bool { return !m.optional }
+
+func newModuleTestService() *Service {
+	return &Service{
+		server: echo.New(),
+		cfg: &config.Config{
+			HTTP: config.HttpConfig{Address: "127.0.0.1:0"},
+		},
+		log: zap.NewNop(),
+	}
+}
+
+func TestServiceModulesLifecycleOrder(t *testing.T) {
+	s := newModuleTestService()
+
+	var (
+		events []string
+		mu     sync.Mutex
+	)
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "first", events: &events, mu: &mu}))
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "second", events: &events, mu: &mu}))
+	assert.Error(t, s.RegisterModule(&recordingModule{name: "first", events: &events, mu: &mu}))
+	assert.Error(t, s.RegisterModule(&recordingModule{name: "database", events: &events, mu: &mu}))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	<-errCh
+
+	assert.Equal(t, []string{
+		"first:init", "second:init",
+		"first:start", "second:start",
+		"second:stop", "first:stop",
+	}, events)
+}
+
+func TestServiceShutdownAggregatesModuleErrors(t *testing.T) {
+	s := newModuleTestService()
+
+	var (
+		events []string
+		mu     sync.Mutex
+	)
+	errFailing := errors.New("boom")
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "failing", events: &events, mu: &mu, stopErr: errFailing}))
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "stuck", events: &events, mu: &mu, block: true, timeout: 50 * time.Millisecond}))
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "healthy", events: &events, mu: &mu}))
+
+	err := s.Shutdown(context.Background())
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errFailing)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "stop stuck module")
+
+	// the stuck module doesn't prevent the others from stopping
+	assert.Equal(t, []string{"failing:init", "stuck:init", "healthy:init", "healthy:stop", "failing:stop"}, events)
+}
+
+func TestServiceStartModulesStopsStartedOnFailure(t *testing.T) {
+	s := newModuleTestService()
+
+	var (
+		events []string
+		mu     sync.Mutex
+	)
+	errFailing := errors.New("boom")
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "first", events: &events, mu: &mu}))
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "second", events: &events, mu: &mu}))
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "failing", events: &events, mu: &mu, startErr: errFailing}))
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "last", events: &events, mu: &mu}))
+
+	err := s.Run()
+	assert.ErrorIs(t, err, errFailing)
+	assert.EqualError(t, err, "start failing module: boom")
+
+	assert.Equal(t, []string{
+		"first:init", "second:init", "failing:init", "last:init",
+		"first:start", "second:start", "failing:start",
+		"second:stop", "first:stop",
+	}, events)
+}
+
+func TestRegisterModuleCritical(t *testing.T) {
+	s := newModuleTestService()
+
+	var (
+		events []string
+		mu     sync.Mutex
+	)
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "consumer", events: &events, mu: &mu, optional: true}))
+	require.NoError(t, s.RegisterModule(&recordingModule{name: "store", events: &events, mu: &mu}))
+
+	results := s.CheckHealth(context.Background())
+	require.Len(t, results, 2)
+	assert.Equal(t, "consumer", results[0].Name)
+	assert.False(t, results[0].Critical)
+	assert.Equal(t, "store", results[1].Name)
+	assert.True(t, results[1].Critical)
+}
@@ -138,9 +138,9 @@ func (s *Service) initObservability() error {
 		metric.WithDescription("Fraction of CPU time used by this process (0–1)"),
 	)
 	var (
-		cpuMu       sync.Mutex
-		lastCPUNs   int64
-		lastWallNs  = time.Now().UnixNano()
+		cpuMu      sync.Mutex
+		lastCPUNs  int64
+		lastWallNs = time.Now().UnixNano()
 	)
 
 	_, _ = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
@@ -0,0 +1,103 @@
+package morondanga
+
+import (
+	"io/fs"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rwbm/morondanga/config"
+	"github.com/rwbm/morondanga/pkg/redis"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Option customizes how NewService builds the service.
+type Option func(o *options)
+
+type options struct {
+	cfg         config.ConfigTemplate
+	log         *zap.Logger
+	db          *gorm.DB
+	redisClient *redis.Client
+	server      *echo.Echo
+	loader      *config.Loader
+	resolvers   map[string]config.SecretResolver
+	migrations  map[string]fs.FS
+}
+
+// WithConfig uses the given configuration instead of loading it from a file,
+// which allows building a service from an in-memory config.Config.
+// Defaults are still applied to it.
+func WithConfig(cfg config.ConfigTemplate) Option {
+	return func(o *options) {
+		o.cfg = cfg
+	}
+}
+
+// WithLogger uses the given logger instead of building one from the configuration.
+func WithLogger(log *zap.Logger) Option {
+	return func(o *options) {
+		o.log = log
+	}
+}
+
+// WithDB uses the given database instead of connecting to the configured one.
+// The service takes ownership of it, so it's closed on Shutdown.
+func WithDB(db *gorm.DB) Option {
+	return func(o *options) {
+		o.db = db
+	}
+}
+
+// WithRedis uses the given redis client instead of connecting to the configured
+// server. The service takes ownership of it, so it's closed on Shutdown.
+func WithRedis(cli *redis.Client) Option {
+	return func(o *options) {
+		o.redisClient = cli
+	}
+}
+
+// WithEcho uses the given echo instance as HTTP server. The service settings,
+// middleware and routes are applied on top of it.
+func WithEcho(e *echo.Echo) Option {
+	return func(o *options) {
+		o.server = e
+	}
+}
+
+// WithLoader loads the configuration file with the given loader, e.g. to
+// select the environment overlay or to bind command line flags.
+func WithLoader(l *config.Loader) Option {
+	return func(o *options) {
+		o.loader = l
+	}
+}
+
+// WithSecretResolver resolves the secret references of scheme found in the
+// configuration file, like ${vault:db/password}, with r. See config.Loader.
+func WithSecretResolver(scheme string, r config.SecretResolver) Option {
+	return func(o *options) {
+		if o.resolvers == nil {
+			o.resolvers = map[string]config.SecretResolver{}
+		}
+		o.resolvers[scheme] = r
+	}
+}
+
+// WithMigrations gives the SQL migrations of the database, read by
+// migrations.New from the root of fsys. They are applied on startup when
+// database.autoMigrate is set, and can be managed with Service.Migrator.
+func WithMigrations(fsys fs.FS) Option {
+	return WithNamedMigrations("", fsys)
+}
+
+// WithNamedMigrations gives the SQL migrations of a database of the
+// databases section, like WithMigrations does for the main one. They can be
+// managed with Service.MigratorNamed.
+func WithNamedMigrations(name string, fsys fs.FS) Option {
+	return func(o *options) {
+		if o.migrations == nil {
+			o.migrations = map[string]fs.FS{}
+		}
+		o.migrations[name] = fsys
+	}
+}
@@ -0,0 +1,91 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"time"
+)
+
+// errLockTimeout is returned when the lock is held by another process for
+// longer than the lock timeout.
+var errLockTimeout = errors.New("timed out waiting for another process to finish migrating")
+
+// dialect holds the database specific parts of the migrator.
+type dialect struct {
+	placeholder func(n int) string
+	// lock takes a session lock named after the version table, waiting until
+	// ctx is done, and unlock releases it
+	lock   func(ctx context.Context, conn *sql.Conn, table string) error
+	unlock func(ctx context.Context, conn *sql.Conn, table string) error
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+		lock: func(ctx context.Context, conn *sql.Conn, table string) error {
+			// cancelled by the driver when ctx is done
+			_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey(table))
+			if err != nil && ctx.Err() != nil {
+				return errLockTimeout
+			}
+			return err
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn, table string) error {
+			_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockKey(table))
+			return err
+		},
+	},
+	"mysql": {
+		placeholder: func(int) string { return "?" },
+		lock: func(ctx context.Context, conn *sql.Conn, table string) error {
+			timeout := -1 // forever
+			if deadline, ok := ctx.Deadline(); ok {
+				timeout = int(math.Ceil(time.Until(deadline).Seconds()))
+			}
+			var got sql.NullInt64
+			if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName(table), timeout).Scan(&got); err != nil {
+				return err
+			}
+			switch {
+			case !got.Valid:
+				return errors.New("GET_LOCK failed")
+			case got.Int64 == 0:
+				return errLockTimeout
+			}
+			return nil
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn, table string) error {
+			_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName(table))
+			return err
+		},
+	},
+	"sqlite": {
+		placeholder: func(int) string { return "?" },
+		// the database is locked while a transaction writes, and it's not
+		// shared by several services
+		lock:   func(context.Context, *sql.Conn, string) error { return nil },
+		unlock: func(context.Context, *sql.Conn, string) error { return nil },
+	},
+}
+
+// lockKey returns the key of the postgres advisory lock of a version table.
+func lockKey(table string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("morondanga:" + table))
+	return int64(h.Sum64())
+}
+
+// lockName returns the name of the mysql lock of a version table, which is
+// limited to 64 characters.
+func lockName(table string) string {
+	name := "morondanga:" + table
+	if len(name) > 64 {
+		name = fmt.Sprintf("morondanga:%x", lockKey(table))
+	}
+	return name
+}
@@ -0,0 +1,215 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubQuery is a statement received by a stubConn.
+type stubQuery struct {
+	sql  string
+	args []any
+}
+
+// stubConnector opens stubConns, which record the statements and answer
+// them with handle: a single value for queries, nothing for execs.
+type stubConnector struct {
+	handle func(ctx context.Context, query string, args []any) (driver.Value, error)
+
+	mu      sync.Mutex
+	queries []stubQuery
+}
+
+func (c *stubConnector) Connect(context.Context) (driver.Conn, error) { return &stubConn{c}, nil }
+func (c *stubConnector) Driver() driver.Driver                        { return nil }
+
+func (c *stubConnector) run(ctx context.Context, query string, named []driver.NamedValue) (driver.Value, error) {
+	args := make([]any, len(named))
+	for i, a := range named {
+		args[i] = a.Value
+	}
+	c.mu.Lock()
+	c.queries = append(c.queries, stubQuery{sql: query, args: args})
+	c.mu.Unlock()
+	if c.handle == nil {
+		return nil, nil
+	}
+	return c.handle(ctx, query, args)
+}
+
+func (c *stubConnector) sql() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]string, len(c.queries))
+	for i, q := range c.queries {
+		out[i] = q.sql
+	}
+	return out
+}
+
+type stubConn struct{ c *stubConnector }
+
+func (c *stubConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *stubConn) Close() error                        { return nil }
+func (c *stubConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *stubConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := c.c.run(ctx, query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	val, err := c.c.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(query, "SELECT version") {
+		return &stubRows{}, nil
+	}
+	return &stubRows{values: []driver.Value{val}}, nil
+}
+
+// stubRows returns values as a single row, or no rows when nil.
+type stubRows struct {
+	values []driver.Value
+	read   bool
+}
+
+func (r *stubRows) Columns() []string {
+	if r.values == nil {
+		return []string{"version", "applied_at"}
+	}
+	return []string{"result"}
+}
+
+func (r *stubRows) Close() error { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if r.values == nil || r.read {
+		return io.EOF
+	}
+	r.read = true
+	copy(dest, r.values)
+	return nil
+}
+
+func newStubConn(t *testing.T, c *stubConnector) *sql.Conn {
+	t.Helper()
+	db := sql.OpenDB(c)
+	t.Cleanup(func() { _ = db.Close() })
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestPostgresLock(t *testing.T) {
+	c := &stubConnector{}
+	conn := newStubConn(t, c)
+	d := dialects["postgres"]
+
+	require.NoError(t, d.lock(context.Background(), conn, "schema_migrations"))
+	require.NoError(t, d.unlock(context.Background(), conn, "schema_migrations"))
+	assert.Equal(t, []stubQuery{
+		{sql: "SELECT pg_advisory_lock($1)", args: []any{lockKey("schema_migrations")}},
+		{sql: "SELECT pg_advisory_unlock($1)", args: []any{lockKey("schema_migrations")}},
+	}, c.queries)
+	assert.Equal(t, "$2", d.placeholder(2))
+
+	// the lock is held by another session until the deadline
+	c.handle = func(ctx context.Context, query string, args []any) (driver.Value, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.lock(ctx, conn, "schema_migrations"), errLockTimeout)
+
+	// other errors are returned as is
+	c.handle = func(context.Context, string, []any) (driver.Value, error) {
+		return nil, errors.New("permission denied")
+	}
+	assert.EqualError(t, d.lock(context.Background(), conn, "schema_migrations"), "permission denied")
+}
+
+func TestMysqlLock(t *testing.T) {
+	var result driver.Value = int64(1)
+	c := &stubConnector{handle: func(context.Context, string, []any) (driver.Value, error) {
+		return result, nil
+	}}
+	conn := newStubConn(t, c)
+	d := dialects["mysql"]
+
+	// waits forever without deadline
+	require.NoError(t, d.lock(context.Background(), conn, "schema_migrations"))
+	require.NoError(t, d.unlock(context.Background(), conn, "schema_migrations"))
+	assert.Equal(t, []stubQuery{
+		{sql: "SELECT GET_LOCK(?, ?)", args: []any{"morondanga:schema_migrations", int64(-1)}},
+		{sql: "SELECT RELEASE_LOCK(?)", args: []any{"morondanga:schema_migrations"}},
+	}, c.queries)
+	assert.Equal(t, "?", d.placeholder(2))
+
+	// the timeout is given in seconds, rounded up
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	require.NoError(t, d.lock(ctx, conn, "schema_migrations"))
+	assert.Equal(t, int64(2), c.queries[2].args[1])
+
+	// 0 means the lock is held by another session until the timeout
+	result = int64(0)
+	assert.ErrorIs(t, d.lock(ctx, conn, "schema_migrations"), errLockTimeout)
+
+	// NULL means an error, like the thread being killed
+	result = nil
+	assert.EqualError(t, d.lock(ctx, conn, "schema_migrations"), "GET_LOCK failed")
+}
+
+func TestMigratorLockTimeout(t *testing.T) {
+	c := &stubConnector{handle: func(ctx context.Context, query string, args []any) (driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT GET_LOCK") {
+			return int64(0), nil
+		}
+		return nil, nil
+	}}
+	m, err := New(sql.OpenDB(c), "mysql", testFiles, WithLockTimeout(time.Second))
+	require.NoError(t, err)
+
+	err = m.Up(context.Background())
+	assert.ErrorIs(t, err, errLockTimeout)
+	// nothing is done without the lock, and there's nothing to release
+	assert.Equal(t, []string{"SELECT GET_LOCK(?, ?)"}, c.sql())
+	assert.Equal(t, int64(1), c.queries[0].args[1])
+}
+
+func TestMigratorHoldsLock(t *testing.T) {
+	c := &stubConnector{handle: func(ctx context.Context, query string, args []any) (driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT GET_LOCK") {
+			return int64(1), nil
+		}
+		return nil, nil
+	}}
+	m, err := New(sql.OpenDB(c), "mysql", fstest.MapFS{})
+	require.NoError(t, err)
+
+	_, err = m.Status(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"SELECT GET_LOCK(?, ?)",
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)",
+		"SELECT version, applied_at FROM schema_migrations",
+		"SELECT RELEASE_LOCK(?)",
+	}, c.sql())
+}
@@ -0,0 +1,422 @@
+// Package migrations applies versioned SQL migrations read from a file
+// system, usually an embed.FS, and keeps track of them in a table.
+//
+// Migrations are pairs of files named after their version, a name and the
+// direction:
+//
+//	0001_create_users.up.sql
+//	0001_create_users.down.sql
+//	0002_add_users_email.up.sql
+//	0002_add_users_email.down.sql
+//
+// Versions are applied in ascending order, each one in its own transaction
+// along with its row in the version table. The down file is optional, but a
+// migration without it can't be rolled back.
+//
+// While migrating, a lock is held in the database, an advisory lock in
+// postgres and GET_LOCK in mysql, so several replicas of a service can start
+// at the same time without racing. sqlite has no such locks; it serializes
+// the writes to the database file instead.
+//
+// Keep in mind that mysql commits DDL statements implicitly, so a failed
+// migration may be left half applied, and that files with several statements
+// require the multiStatements driver parameter.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultTable is the name of the table that keeps the applied versions.
+	DefaultTable = "schema_migrations"
+	// DefaultLockTimeout is the time to wait for another process to finish
+	// migrating.
+	DefaultLockTimeout = time.Minute
+)
+
+var (
+	filePattern  = regexp.MustCompile(`^(\d+)_([^.]+)\.(up|down)\.sql$`)
+	tablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+)
+
+// Migration is a version of the schema.
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+	hasUp   bool
+	hasDown bool
+}
+
+// Status tells whether a migration is applied, and when.
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies the migrations of a file system to a database.
+type Migrator struct {
+	db          *sql.DB
+	dialect     dialect
+	table       string
+	lockTimeout time.Duration
+	log         *zap.Logger
+	migrations  []Migration
+}
+
+// Option customizes a Migrator.
+type Option func(m *Migrator)
+
+// WithTable keeps the applied versions in the given table instead of
+// DefaultTable. It may include the schema, e.g. app.schema_migrations.
+func WithTable(table string) Option {
+	return func(m *Migrator) {
+		m.table = table
+	}
+}
+
+// WithLockTimeout sets the time to wait for the migration lock, which is
+// DefaultLockTimeout by default.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockTimeout = timeout
+	}
+}
+
+// WithLogger logs every applied and rolled back migration.
+func WithLogger(log *zap.Logger) Option {
+	return func(m *Migrator) {
+		m.log = log
+	}
+}
+
+// New creates a migrator for the migrations at the root of fsys. Use fs.Sub
+// for the ones in a directory of an embed.FS:
+//
+//	//go:embed migrations/*.sql
+//	var migrationFiles embed.FS
+//
+//	files, _ := fs.Sub(migrationFiles, "migrations")
+//	m, err := migrations.New(sqlDB, "postgres", files)
+//
+// The driver is the one of the database configuration: mysql, postgres or
+// sqlite.
+func New(db *sql.DB, driver string, fsys fs.FS, opts ...Option) (*Migrator, error) {
+	d, ok := dialects[strings.ToLower(driver)]
+	if !ok {
+		return nil, fmt.Errorf("migrations not supported for driver: %s", driver)
+	}
+
+	m := &Migrator{
+		db:          db,
+		dialect:     d,
+		table:       DefaultTable,
+		lockTimeout: DefaultLockTimeout,
+		log:         zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if !tablePattern.MatchString(m.table) {
+		return nil, fmt.Errorf("invalid migrations table name: %q", m.table)
+	}
+
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	m.migrations = migrations
+	return m, nil
+}
+
+// load reads the migration files at the root of fsys, sorted by version.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := filePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", entry.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		} else if mig.Name != match[2] {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, mig.Name, match[2])
+		}
+		if match[3] == "up" {
+			mig.up = string(content)
+			mig.hasUp = true
+		} else {
+			mig.down = string(content)
+			mig.hasDown = true
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if !mig.hasUp {
+			return nil, fmt.Errorf("migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrations returns the migrations found, sorted by version.
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration(nil), m.migrations...)
+}
+
+// Up applies every pending migration, including those with a version lower
+// than the current one that weren't applied yet.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.run(ctx, func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the last applied migration. It does nothing when no
+// migration is applied.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.run(ctx, func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error {
+		versions := sortedVersions(applied)
+		if len(versions) == 0 {
+			return nil
+		}
+		return m.rollback(ctx, conn, versions[len(versions)-1])
+	})
+}
+
+// To migrates up or down to version: the pending migrations up to version are
+// applied, and the applied ones above it are rolled back, from the newest.
+// Version 0 rolls back every migration.
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("migration %d not found", version)
+	}
+	return m.run(ctx, func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error {
+		versions := sortedVersions(applied)
+		for i := len(versions) - 1; i >= 0 && versions[i] > version; i-- {
+			if err := m.rollback(ctx, conn, versions[i]); err != nil {
+				return err
+			}
+		}
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status returns every migration, found or applied, sorted by version.
+// Applied versions without files have no name.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var out []Status
+	err := m.run(ctx, func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range m.migrations {
+			at, ok := applied[mig.Version]
+			out = append(out, Status{Version: mig.Version, Name: mig.Name, Applied: ok, AppliedAt: at})
+		}
+		for version, at := range applied {
+			if m.find(version) == nil {
+				out = append(out, Status{Version: version, Applied: true, AppliedAt: at})
+			}
+		}
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, err
+}
+
+// Version returns the newest applied version, or 0 if none.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	var version int64
+	err := m.run(ctx, func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error {
+		if versions := sortedVersions(applied); len(versions) > 0 {
+			version = versions[len(versions)-1]
+		}
+		return nil
+	})
+	return version, err
+}
+
+// run calls fn holding the lock, on a single connection, so session locks
+// are held by the same session that migrates.
+func (m *Migrator) run(ctx context.Context, fn func(ctx context.Context, conn *sql.Conn, applied map[int64]time.Time) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("migrations connection: %w", err)
+	}
+	defer conn.Close()
+
+	lockCtx, cancel := context.WithTimeout(ctx, m.lockTimeout)
+	err = m.dialect.lock(lockCtx, conn, m.table)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("migrations lock: %w", err)
+	}
+	defer func() {
+		// released even if ctx is done, or the connection would keep it
+		if err := m.dialect.unlock(context.WithoutCancel(ctx), conn, m.table); err != nil {
+			m.log.Warn("Failed to release the migrations lock", zap.Error(err))
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)",
+		m.table,
+	)); err != nil {
+		return fmt.Errorf("create migrations table: %w", err)
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(ctx, conn, applied)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, applied_at FROM %s", m.table))
+	if err != nil {
+		return nil, fmt.Errorf("read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("read applied migrations: %w", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (m *Migrator) rollback(ctx context.Context, conn *sql.Conn, version int64) error {
+	mig := m.find(version)
+	if mig == nil {
+		return fmt.Errorf("migration %d is applied, but not found", version)
+	}
+	if !mig.hasDown {
+		return fmt.Errorf("migration %d_%s has no down file", mig.Version, mig.Name)
+	}
+	return m.apply(ctx, conn, *mig, false)
+}
+
+// apply runs a migration in a transaction, along with the update of the
+// version table.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	script, direction := mig.up, "up"
+	if !up {
+		script, direction = mig.down, "down"
+	}
+	start := time.Now()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+	err = func() error {
+		if strings.TrimSpace(script) != "" {
+			if _, err := tx.ExecContext(ctx, script); err != nil {
+				return err
+			}
+		}
+		if up {
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(
+				"INSERT INTO %s (version, name, applied_at) VALUES (%s, %s, %s)",
+				m.table, m.dialect.placeholder(1), m.dialect.placeholder(2), m.dialect.placeholder(3),
+			), mig.Version, mig.Name, time.Now().UTC())
+			return err
+		}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(
+			"DELETE FROM %s WHERE version = %s", m.table, m.dialect.placeholder(1),
+		), mig.Version)
+		return err
+	}()
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("migration %d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+
+	m.log.Info("Migration applied",
+		zap.Int64("version", mig.Version),
+		zap.String("name", mig.Name),
+		zap.String("direction", direction),
+		zap.Duration("duration", time.Since(start)),
+	)
+	return nil
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+func sortedVersions(applied map[int64]time.Time) []int64 {
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
@@ -0,0 +1,120 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFiles = fstest.MapFS{
+	"0001_create_users.up.sql":    {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY)")},
+	"0001_create_users.down.sql":  {Data: []byte("DROP TABLE users")},
+	"0002_add_email.up.sql":       {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT")},
+	"0002_add_email.down.sql":     {Data: []byte("ALTER TABLE users DROP COLUMN email")},
+	"0003_create_orders.up.sql":   {Data: []byte("CREATE TABLE orders (id INTEGER PRIMARY KEY)")},
+	"0003_create_orders.down.sql": {Data: []byte("DROP TABLE orders")},
+	"README.md":                   {Data: []byte("ignored")},
+}
+
+func newTestMigrator(t *testing.T, fsys fstest.MapFS) (*Migrator, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	m, err := New(db, "sqlite", fsys)
+	require.NoError(t, err)
+	return m, db
+}
+
+func appliedVersions(t *testing.T, m *Migrator) []int64 {
+	t.Helper()
+	status, err := m.Status(context.Background())
+	require.NoError(t, err)
+	var out []int64
+	for _, s := range status {
+		if s.Applied {
+			out = append(out, s.Version)
+		}
+	}
+	return out
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	ctx := context.Background()
+	m, db := newTestMigrator(t, testFiles)
+
+	status, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, 3)
+	assert.Equal(t, "create_users", status[0].Name)
+	assert.False(t, status[0].Applied)
+
+	require.NoError(t, m.Up(ctx))
+	assert.Equal(t, []int64{1, 2, 3}, appliedVersions(t, m))
+	_, err = db.Exec("INSERT INTO users (id, email) VALUES (1, 'jane@example.com')")
+	require.NoError(t, err)
+
+	// nothing left to apply
+	require.NoError(t, m.Up(ctx))
+
+	require.NoError(t, m.Down(ctx))
+	assert.Equal(t, []int64{1, 2}, appliedVersions(t, m))
+	version, err := m.Version(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, version)
+
+	require.NoError(t, m.To(ctx, 1))
+	assert.Equal(t, []int64{1}, appliedVersions(t, m))
+	require.NoError(t, m.To(ctx, 3))
+	assert.Equal(t, []int64{1, 2, 3}, appliedVersions(t, m))
+	require.NoError(t, m.To(ctx, 0))
+	assert.Empty(t, appliedVersions(t, m))
+
+	assert.ErrorContains(t, m.To(ctx, 42), "migration 42 not found")
+}
+
+func TestMigratorFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	files := fstest.MapFS{
+		"1_create_users.up.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY)")},
+		"2_broken.up.sql":       {Data: []byte("ALTER TABLE missing ADD COLUMN name TEXT")},
+	}
+	m, _ := newTestMigrator(t, files)
+
+	err := m.Up(ctx)
+	assert.ErrorContains(t, err, "migration 2_broken up")
+	assert.Equal(t, []int64{1}, appliedVersions(t, m))
+
+	// no down file
+	assert.ErrorContains(t, m.Down(ctx), "migration 1_create_users has no down file")
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := load(fstest.MapFS{
+		"1_a.up.sql": {Data: []byte("SELECT 1")},
+		"1_b.up.sql": {Data: []byte("SELECT 1")},
+	})
+	assert.ErrorContains(t, err, "duplicate migration version 1")
+
+	_, err = load(fstest.MapFS{"1_a.down.sql": {Data: []byte("SELECT 1")}})
+	assert.ErrorContains(t, err, "has no up file")
+
+	_, err = New(nil, "oracle", fstest.MapFS{})
+	assert.ErrorContains(t, err, "not supported")
+
+	_, err = New(nil, "postgres", fstest.MapFS{}, WithTable("users; DROP TABLE users"))
+	assert.ErrorContains(t, err, "invalid migrations table name")
+}
+
+func TestLockName(t *testing.T) {
+	assert.Equal(t, "morondanga:schema_migrations", lockName(DefaultTable))
+	assert.LessOrEqual(t, len(lockName("app_with_a_very_long_schema_name.and_a_very_long_table_name")), 64)
+	assert.Equal(t, lockKey(DefaultTable), lockKey(DefaultTable))
+	assert.NotEqual(t, lockKey(DefaultTable), lockKey("other"))
+}
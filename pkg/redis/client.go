@@ -13,7 +13,17 @@ type Client struct {
 	Base *redis.Client
 }
 
+// NewClient creates a client and checks the connection, waiting up to 5 seconds
+// for the server to answer.
 func NewClient(address, password string, database int) (*Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return NewClientContext(ctx, address, password, database)
+}
+
+// NewClientContext creates a client and checks the connection, waiting for
+// the server to answer until ctx is done.
+func NewClientContext(ctx context.Context, address, password string, database int) (*Client, error) {
 	if address == "" {
 		return nil, errors.New("redis address is required")
 	}
@@ -24,12 +34,20 @@ func NewClient(address, password string, database int) (*Client, error) {
 		DB:       database,
 	})
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	if err := base.Ping(ctx).Err(); err != nil {
+		_ = base.Close()
 		return nil, fmt.Errorf("redis ping failed: %w", err)
 	}
 
 	return &Client{base}, nil
 }
+
+// Ping checks the connection to the redis server.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.Base.Ping(ctx).Err()
+}
+
+// Close closes the client, releasing any open resources.
+func (c *Client) Close() error {
+	return c.Base.Close()
+}
@@ -2,40 +2,77 @@ package morondanga
 
 import (
 	"context"
+	"crypto/tls"
+	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/rwbm/morondanga/config"
-	"github.com/rwbm/morondanga/logging"
 	"github.com/rwbm/morondanga/pkg/redis"
 	"github.com/uptrace/opentelemetry-go-extra/otelsql"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/glebarez/sqlite"
 	"github.com/labstack/echo/v4"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	gormlogger "gorm.io/gorm/logger"
 )
 
 // Represents the main component, that presents a basic set
 // of modules that can be enabled or disabled by configuration.
 type Service struct {
-	server       *echo.Echo
-	cfg          config.ConfigTemplate
-	log          *zap.Logger
-	db           *gorm.DB
-	redisClient  *redis.Client
-	healthCheck  func(c echo.Context) error
-	jwtHandler   echo.MiddlewareFunc
-	tracer       trace.Tracer
-	otelShutdown func()
+	server *echo.Echo
+	admin  *echo.Echo
+	cfg    config.ConfigTemplate
+	cfgMu  sync.RWMutex
+	loader *config.Loader
+	// cfgSubscribers are guarded by cfgMu
+	cfgSubscribers []ConfigChangeFunc
+	web            atomic.Pointer[webSettings]
+	log            *zap.Logger
+	db             *gorm.DB
+	dbResources    *databaseResources
+	namedDBs       map[string]*namedDatabase
+	migrations     map[string]fs.FS
+	redisClient    *redis.Client
+	healthCheck    func(c echo.Context) error
+	jwtHandler     echo.MiddlewareFunc
+	tracer         trace.Tracer
+	otelShutdown   func()
+	draining       atomic.Bool
+
+	modulesMu      sync.Mutex
+	modules        []Module
+	modulesStarted bool
+	// builtins are the built-in modules as of init; the named databases of
+	// a configuration reloaded later don't change them
+	builtins []Module
+
+	workersMu         sync.Mutex
+	workersWG         sync.WaitGroup
+	workersCtx        context.Context
+	workersCancel     context.CancelFunc
+	workers           []*worker
+	workerMetricsOnce sync.Once
+	workerMeters      *workerMetrics
+
+	healthMu     sync.Mutex
+	healthChecks []*healthCheck
+
+	// depsMu guards db, dbResources, namedDBs and redisClient, which are set in the background
+	// when connecting in lazy mode
+	depsMu sync.RWMutex
 }
 
 var dialectorFactory = defaultDialectorFactory
@@ -54,10 +91,13 @@ func defaultDialectorFactory(driver, dsn string) (gorm.Dialector, error) {
 		return mysql.Open(dsn), nil
 	case "postgres":
 		return postgres.Open(dsn), nil
+	case "sqlite":
+		return sqlite.Open(dsn), nil
 	default:
 		return nil, fmt.Errorf("unsupported database driver: %s", driver)
 	}
 }
+
 // Sets the Validator used for the HTTP server.
 func (s *Service) WithHttpValidator(v Validator) *Service {
 	s.server.Validator = v
@@ -78,9 +118,22 @@ func (s *Service) WithHttpHealthCheck(route string, f func(c echo.Context) error
 
 // Returns the configuration instance.
 func (s *Service) Configuration() config.ConfigTemplate {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
 	return s.cfg
 }
 
+// EffectiveConfiguration returns every configuration value along with the
+// layer it comes from: a file, an environment variable, a flag or the defaults.
+// Sensitive values are redacted, as in config.Dump.
+// It returns nil if the configuration was not loaded from a file.
+func (s *Service) EffectiveConfiguration() []config.EffectiveValue {
+	if s.loader == nil {
+		return nil
+	}
+	return s.loader.Effective(s.Configuration())
+}
+
 // Logger instance.
 func (s *Service) Log() *zap.Logger {
 	return s.log
@@ -95,14 +148,23 @@ func (s *Service) SetLogger(log *zap.Logger) {
 
 // Returns the database instance, which is just an instance of gorm.DB
 // connected to the configured database.
+//
+// When connecting in lazy mode, it returns nil until the connection is established.
 func (s *Service) Database() *gorm.DB {
+	s.depsMu.RLock()
+	defer s.depsMu.RUnlock()
 	return s.db
 }
 
 // Returns the redis client instance, if it's enabled.
+//
+// When connecting in lazy mode, it returns nil until the connection is established.
 func (s *Service) Redis() *redis.Client {
+	s.depsMu.RLock()
+	defer s.depsMu.RUnlock()
 	return s.redisClient
 }
+
 // Starts the service, by starting the HTTP server and all the enabled modules,
 // like the database and cache connection.
 //
@@ -113,11 +175,45 @@ func (s *Service) Redis() *redis.Client {
 // It will always return a non-nil error, which must be checked. If everything is fine
 // and the server was stopped, then http.ErrServerClosed will be returned.
 func (s *Service) Run() error {
-	if s.Configuration().GetHTTP().JwtEnabled && s.Configuration().GetHTTP().JwtSigningKey == config.DefaultJwtSigningKey {
-		s.Log().Warn("Using default jwt signing key! Please, use a different one")
+	if err := s.startModules(context.Background()); err != nil {
+		return err
+	}
+
+	// bind the listeners upfront, so binding errors are returned here
+	// instead of happening in the background
+	ln, err := s.listen(listenerHTTP, s.Configuration().GetHTTP().Address)
+	if err != nil {
+		return fmt.Errorf("http server start: %w", err)
+	}
+	if tlsCfg := s.Configuration().GetHTTP().TLS; tlsCfg.Enabled {
+		serverTLS, err := s.newTLSConfig(tlsCfg)
+		if err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("http server start: %w", err)
+		}
+		s.server.Server.TLSConfig = serverTLS
+		s.server.TLSListener = tls.NewListener(ln, serverTLS)
+	} else {
+		s.server.Listener = ln
 	}
 
-	err := s.server.Start(s.cfg.GetHTTP().Address)
+	if s.admin != nil {
+		adminLn, err := s.listen(listenerAdmin, s.Configuration().GetAdmin().Address)
+		if err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("admin server start: %w", err)
+		}
+		s.admin.Listener = adminLn
+
+		go func() {
+			if err := s.admin.Start(s.Configuration().GetAdmin().Address); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.Log().Error("Admin server stopped unexpectedly", zap.Error(err))
+			}
+		}()
+	}
+
+	s.server.Server.Addr = s.Configuration().GetHTTP().Address
+	err = s.server.StartServer(s.server.Server)
 	if errors.Is(err, http.ErrServerClosed) {
 		return http.ErrServerClosed
 	}
@@ -127,7 +223,85 @@ func (s *Service) Run() error {
 	return nil
 }
 
-// Shutdown stops the server gracefully.
+// RunWithSignals starts the service like Run, and stops it gracefully when the
+// process receives SIGINT or SIGTERM. See RunContext for the shutdown sequence.
+//
+// A second signal received while draining terminates the process immediately.
+func (s *Service) RunWithSignals() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// restore the default signal behavior once the first signal arrived,
+	// so a second one kills the process if draining gets stuck
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	return s.RunContext(ctx)
+}
+
+// RunContext starts the service like Run, and stops it gracefully when ctx is done:
+//
+//  1. the service is marked as not ready, so the health check starts failing;
+//  2. it keeps serving during HttpConfig.ShutdownDelay, so load balancers have time
+//     to take the instance out of rotation;
+//  3. in-flight requests are drained and Shutdown is called, bounded by
+//     HttpConfig.ShutdownTimeout.
+//
+// It returns nil if the service was stopped gracefully, and the startup or
+// shutdown error otherwise.
+func (s *Service) RunContext(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	httpCfg := s.Configuration().GetHTTP()
+	s.draining.Store(true)
+	s.Log().Info("Shutdown requested; service marked as not ready",
+		zap.Duration("shutdownDelay", httpCfg.ShutdownDelay),
+		zap.Duration("shutdownTimeout", httpCfg.ShutdownTimeout),
+	)
+
+	if httpCfg.ShutdownDelay > 0 {
+		time.Sleep(httpCfg.ShutdownDelay)
+	}
+
+	timeout := httpCfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = config.DefaultHttpShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := s.Shutdown(shutdownCtx)
+	if runErr := <-errCh; runErr != nil && !errors.Is(runErr, http.ErrServerClosed) {
+		err = errors.Join(runErr, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Log().Info("Service stopped gracefully")
+	return nil
+}
+
+// Ready reports whether the service is ready to receive traffic.
+// It turns false as soon as a graceful shutdown starts.
+func (s *Service) Ready() bool {
+	return !s.draining.Load()
+}
+
+// Shutdown stops the server gracefully, then the admin server, cancels the background workers and
+// waits for them, and then stops all the modules in reverse order. Errors are aggregated, so a failing step doesn't
+// prevent the following ones from running.
 func (s *Service) Shutdown(ctx context.Context) error {
 	var errs []error
 
@@ -137,26 +311,30 @@ func (s *Service) Shutdown(ctx context.Context) error {
 		}
 	}
 
-	if s.db != nil {
-		sqlDB, err := s.db.DB()
-		if err != nil {
-			errs = append(errs, fmt.Errorf("database handle: %w", err))
-		} else if err := sqlDB.Close(); err != nil {
-			errs = append(errs, fmt.Errorf("database close: %w", err))
+	// the admin server goes after the public one, so probes keep working while draining
+	if s.admin != nil {
+		if err := s.admin.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("admin server shutdown: %w", err))
 		}
 	}
 
-	if s.otelShutdown != nil {
-		s.otelShutdown()
+	if err := s.stopWorkers(ctx); err != nil {
+		errs = append(errs, err)
 	}
 
+	errs = append(errs, s.stopModules(ctx)...)
+
 	return errors.Join(errs...)
 }
 
 // NewService creates a returns a new instance of Service.
-func NewService(configFilePath string) (*Service, error) {
+//
+// Options can be given to use an in-memory configuration instead of a file
+// (see WithConfig), or to inject pre-built dependencies instead of creating
+// them from the configuration.
+func NewService(configFilePath string, opts ...Option) (*Service, error) {
 	cfg := &config.Config{}
-	return newService(configFilePath, cfg)
+	return newService(configFilePath, cfg, opts...)
 }
 
 // Creates a returns a new instance of Service
@@ -165,59 +343,73 @@ func NewService(configFilePath string) (*Service, error) {
 // This configuration type must follow config.ConfigTemplate
 // interface in order to work, and it must also expose the fields App, HTTP, Database and Custom,
 // and the custom structures, in order for the Marshall function can work properly.
-func NewServiceWithCustomConfiguration(configFilePath string, cfg config.ConfigTemplate) (*Service, error) {
+func NewServiceWithCustomConfiguration(configFilePath string, cfg config.ConfigTemplate, opts ...Option) (*Service, error) {
 	if cfg == nil {
 		return nil, errors.New("the configuration template cannot be nil")
 	}
-	return newService(configFilePath, cfg)
+	return newService(configFilePath, cfg, opts...)
 }
 
-func newService(configFilePath string, cfg config.ConfigTemplate) (*Service, error) {
-	s := &Service{}
-
-	// load configuration file
-	s.cfg = cfg
-	if err := s.initConfig(configFilePath, cfg); err != nil {
-		return nil, err
+func newService(configFilePath string, cfg config.ConfigTemplate, opts ...Option) (*Service, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	// init observability (OTEL) — must be before logger so bridge is active
-	if err := s.initObservability(); err != nil {
-		return nil, fmt.Errorf("init observability: %w", err)
+	s := &Service{
+		log:         o.log,
+		db:          o.db,
+		redisClient: o.redisClient,
+		server:      o.server,
+		migrations:  o.migrations,
 	}
 
-	// set logger (with optional otelzap bridge when observability is enabled)
-	obs := s.Configuration().GetObservability()
-	s.log = logging.GetWithConfig(
-		s.Configuration().GetApp().LogLevel,
-		s.Configuration().GetApp().LogFormat,
-		logging.OTELOptions{
-			Enabled:     obs != nil && obs.Enabled,
-			ServiceName: s.Configuration().GetApp().Name,
-		})
-
-	// configure database
-	if s.Configuration().GetDatabase().Enabled {
-		if err := s.initDatabase(); err != nil {
+	// load configuration file, unless it was given
+	if o.cfg != nil {
+		s.cfg = o.cfg
+		s.Configuration().SetDefaults()
+	} else {
+		s.cfg = cfg
+		if err := s.initConfig(configFilePath, cfg, o.loader, o.resolvers); err != nil {
 			return nil, err
 		}
 	}
 
-	// configure redis
-	if s.Configuration().GetRedis().Enabled {
-		if err := s.initRedis(); err != nil {
-			return nil, err
-		}
+	// refuse to start with an invalid configuration; the whole template is
+	// checked, so the rules of custom sections apply too, and so do the ones
+	// of a Validate override
+	if err := config.ValidateTemplate(s.cfg); err != nil {
+		return nil, err
+	}
+
+	// init built-in modules: observability (OTEL) must be before the logger
+	// so the bridge is active, and the logger before database and redis
+	if err := s.initBuiltinModules(context.Background()); err != nil {
+		return nil, err
 	}
 
+	// health checks for the enabled integrations
+	s.registerBuiltinHealthChecks()
+
 	// configure web server
 	s.initWebServer()
 
+	if s.Configuration().GetApp().WatchConfig {
+		s.watchConfig()
+	}
+
 	return s, nil
 }
 
-func (s *Service) initConfig(cfgFile string, cfg config.ConfigTemplate) error {
-	err := config.GetConfiguration(cfgFile, cfg)
+func (s *Service) initConfig(cfgFile string, cfg config.ConfigTemplate, loader *config.Loader, resolvers map[string]config.SecretResolver) error {
+	s.loader = loader
+	if s.loader == nil {
+		s.loader = config.NewLoader()
+	}
+	for scheme, r := range resolvers {
+		s.loader.RegisterSecretResolver(scheme, r)
+	}
+	err := s.loader.Load(cfgFile, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to load configuration file: %s", err)
 	}
@@ -227,63 +419,181 @@ func (s *Service) initConfig(cfgFile string, cfg config.ConfigTemplate) error {
 }
 
 func (s *Service) initDatabase() error {
-	newLogger := gormlogger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags),
-		gormlogger.Config{
-			SlowThreshold:             time.Second,
-			LogLevel:                  gormlogger.Info,
-			IgnoreRecordNotFoundError: true,
-			ParameterizedQueries:      true,
-			Colorful:                  true,
-		},
-	)
-
 	dbCfg := s.Configuration().GetDatabase()
+	return s.connectDependency("database", dbCfg.Connect, func(ctx context.Context) error {
+		db, res, err := s.connectDatabase(ctx, "", dbCfg)
+		if err != nil {
+			return err
+		}
+		s.depsMu.Lock()
+		s.db = db
+		s.dbResources = res
+		s.depsMu.Unlock()
+		return nil
+	})
+}
+
+func (s *Service) initNamedDatabase(name string, dbCfg *config.DatabaseConfig) error {
+	return s.connectDependency(databaseName(name), dbCfg.Connect, func(ctx context.Context) error {
+		db, res, err := s.connectDatabase(ctx, name, dbCfg)
+		if err != nil {
+			return err
+		}
+		s.depsMu.Lock()
+		if s.namedDBs == nil {
+			s.namedDBs = map[string]*namedDatabase{}
+		}
+		s.namedDBs[name] = &namedDatabase{db: db, res: res}
+		s.depsMu.Unlock()
+		return nil
+	})
+}
+
+// connectDatabase opens a database, the main one when name is empty, applies
+// its migrations and sets up its replicas and metrics.
+func (s *Service) connectDatabase(ctx context.Context, name string, dbCfg *config.DatabaseConfig) (*gorm.DB, *databaseResources, error) {
+	db, err := s.openDatabase(ctx, dbCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeDB := func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	}
+
+	if dbCfg.AutoMigrate {
+		if err := s.migrate(ctx, name, db, dbCfg); err != nil {
+			closeDB()
+			return nil, nil, err
+		}
+	}
+
+	res := &databaseResources{}
+	resName := databaseName(name)
+	if len(dbCfg.Replicas) > 0 {
+		if res.replicas, err = s.useReplicas(db, dbCfg, resName); err != nil {
+			closeDB()
+			return nil, nil, err
+		}
+		s.Go(resName+"-replicas", res.replicas.monitor(dbCfg.ReplicaHealthInterval))
+		for _, r := range res.replicas.replicas {
+			s.AddHealthCheck(r.name, func(context.Context) error { return r.healthErr() }, false)
+		}
+	}
+
+	pools := map[string]*sql.DB{}
+	if sqlDB, err := db.DB(); err == nil {
+		pools[resName] = sqlDB
+	}
+	if res.replicas != nil {
+		for _, r := range res.replicas.replicas {
+			pools[r.name] = r.db
+		}
+	}
+	for pool, sqlDB := range pools {
+		reg, err := s.registerPoolMetrics(pool, sqlDB)
+		if err != nil {
+			s.Log().Warn("Database pool metrics not available", zap.String("pool", pool), zap.Error(err))
+			continue
+		}
+		res.metrics = append(res.metrics, reg)
+	}
+	return db, res, nil
+}
+
+// openSQL opens the connection pool of dbCfg, instrumented when its queries
+// are traced.
+func (s *Service) openSQL(dbCfg *config.DatabaseConfig) (*sql.DB, error) {
+	driver := sqlDriverName(strings.ToLower(strings.TrimSpace(dbCfg.Driver)))
+	if s.databaseTracing(dbCfg) {
+		return otelsql.Open(driver, dbCfg.ConnectionString(), otelsql.WithDBName(dbCfg.Database))
+	}
+	return sql.Open(driver, dbCfg.ConnectionString())
+}
+
+// databaseTracing tells if the queries of a connection are traced: when
+// observability is enabled, unless the connection turns it off.
+func (s *Service) databaseTracing(dbCfg *config.DatabaseConfig) bool {
+	obs := s.Configuration().GetObservability()
+	return obs != nil && obs.Enabled && !dbCfg.DisableTracing
+}
+
+// openDatabase opens the database of dbCfg and pings it, bounded by ctx.
+func (s *Service) openDatabase(ctx context.Context, dbCfg *config.DatabaseConfig) (*gorm.DB, error) {
+	if err := dbCfg.RegisterTLS(); err != nil {
+		return nil, fmt.Errorf("database tls: %w", err)
+	}
 	connString := dbCfg.ConnectionString()
 	driver := strings.ToLower(strings.TrimSpace(dbCfg.Driver))
 
 	var dialector gorm.Dialector
-	if obs := s.Configuration().GetObservability(); obs != nil && obs.Enabled {
-		sqlDB, err := otelsql.Open(sqlDriverName(driver), connString)
+	if s.databaseTracing(dbCfg) {
+		sqlDB, err := s.openSQL(dbCfg)
 		if err != nil {
-			return fmt.Errorf("open sql db: %w", err)
+			return nil, fmt.Errorf("open sql db: %w", err)
 		}
 		switch driver {
 		case "mysql":
 			dialector = mysql.New(mysql.Config{Conn: sqlDB})
 		case "postgres":
 			dialector = postgres.New(postgres.Config{Conn: sqlDB})
+		case "sqlite":
+			dialector = &sqlite.Dialector{Conn: sqlDB}
 		default:
 			_ = sqlDB.Close()
-			return fmt.Errorf("unsupported database driver: %s", driver)
+			return nil, fmt.Errorf("unsupported database driver: %s", driver)
 		}
 	} else {
 		var err error
 		dialector, err = dialectorFactory(driver, connString)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	db, err := gorm.Open(dialector, &gorm.Config{Logger: newLogger})
+	// gorm pings without a context, so the ping is done here instead, and
+	// a dial that hangs doesn't outlive the connection timeout
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger:               newGormLogger(s.Log(), dbCfg),
+		DisableAutomaticPing: true,
+	})
+	if err == nil {
+		if pinger, ok := db.ConnPool.(interface{ PingContext(context.Context) error }); ok {
+			err = pinger.PingContext(ctx)
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("failed to connect to the database: %w", err)
+		// the pool is left open when the ping fails; close it before retrying
+		if db != nil {
+			if sqlDB, dbErr := db.DB(); dbErr == nil {
+				_ = sqlDB.Close()
+			}
+		}
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
 	}
 
-	s.db = db
-	return nil
+	if sqlDB, err := db.DB(); err == nil {
+		configurePool(sqlDB, dbCfg)
+	}
+
+	return db, nil
 }
 
 func (s *Service) initRedis() error {
 	redisCfg := s.Configuration().GetRedis()
-	cli, err := redis.NewClient(
-		redisCfg.Address,
-		redisCfg.Password,
-		redisCfg.Database,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create redis client: %w", err)
-	}
-	s.redisClient = cli
-	return nil
+	return s.connectDependency("redis", redisCfg.Connect, func(ctx context.Context) error {
+		cli, err := redis.NewClientContext(ctx,
+			redisCfg.Address,
+			redisCfg.Password,
+			redisCfg.Database,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create redis client: %w", err)
+		}
+		s.depsMu.Lock()
+		s.redisClient = cli
+		s.depsMu.Unlock()
+		return nil
+	})
 }
@@ -0,0 +1,80 @@
+package morondanga
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/rwbm/morondanga/config"
+	"github.com/rwbm/morondanga/logging"
+)
+
+// Admin returns the admin HTTP server, or nil if it's not enabled.
+// It can be used to register additional internal routes.
+func (s *Service) Admin() *echo.Echo {
+	return s.admin
+}
+
+// initAdminServer configures the admin HTTP server, which serves the health,
+// log level, routes and pprof endpoints apart from the public address.
+// It doesn't log requests nor use JWT.
+func (s *Service) initAdminServer() {
+	adminCfg := s.Configuration().GetAdmin()
+
+	s.admin = echo.New()
+	s.admin.HideBanner = true
+	s.admin.HidePort = true
+	s.admin.Logger.SetLevel(s.server.Logger.Level())
+	s.admin.Server.ReadTimeout = adminCfg.ReadTimeout
+	s.admin.Server.WriteTimeout = adminCfg.WriteTimeout
+	s.admin.Server.IdleTimeout = adminCfg.IdleTimeout
+
+	s.admin.Use(echoMiddleware.Recover())
+
+	// healthcheck
+	if !s.Configuration().GetHTTP().CustomHealthCheck {
+		s.setHealthCheck(s.admin)
+	}
+
+	// runtime log level; GET returns the current level and PUT changes it,
+	// e.g. {"level":"debug"}
+	s.admin.Any("/loglevel", echo.WrapHandler(logging.AtomicLevel()))
+
+	// routes registered in the public server
+	s.admin.GET("/routes", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, s.server.Routes())
+	})
+
+	// effective configuration, with secrets redacted; ?format=yaml for YAML
+	if adminCfg.ConfigDump {
+		s.admin.GET("/config", s.configDumpHandler)
+	}
+
+	if adminCfg.Pprof {
+		pprofGroup := s.admin.Group("/debug/pprof")
+		pprofGroup.GET("/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+		pprofGroup.GET("/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+		pprofGroup.Any("/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+		pprofGroup.GET("/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+		// the index also serves the named profiles, like /debug/pprof/heap
+		pprofGroup.GET("*", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+	}
+}
+
+func (s *Service) configDumpHandler(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = config.FormatJSON
+	}
+	out, err := config.Dump(s.Configuration(), format)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	contentType := echo.MIMEApplicationJSON
+	if format == config.FormatYAML {
+		contentType = "application/yaml"
+	}
+	return c.Blob(http.StatusOK, contentType, out)
+}
@@ -0,0 +1,117 @@
+package morondanga
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rwbm/morondanga/config"
+	"github.com/rwbm/morondanga/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestServiceAdminServer(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	s := &Service{
+		server: echo.New(),
+		cfg: &config.Config{
+			HTTP: config.HttpConfig{Address: "127.0.0.1:0"},
+			Admin: config.AdminConfig{
+				Enabled: true,
+				Address: "127.0.0.1:0",
+				Pprof:   true,
+			},
+		},
+		log: zap.NewNop(),
+	}
+	s.initAdminServer()
+	s.GET("/hello", func(c echo.Context) error { return c.String(http.StatusOK, "hello") })
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run()
+	}()
+	// Allow servers to start.
+	time.Sleep(100 * time.Millisecond)
+
+	publicURL := "http://" + s.server.ListenerAddr().String()
+	adminURL := "http://" + s.admin.ListenerAddr().String()
+
+	get := func(url string) (int, string) {
+		res, err := http.Get(url)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	code, _ := get(adminURL + "/health")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get(publicURL + "/health")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body := get(adminURL + "/routes")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "/hello")
+
+	code, _ = get(adminURL + "/debug/pprof/heap")
+	assert.Equal(t, http.StatusOK, code)
+
+	req, _ := http.NewRequest(http.MethodPut, adminURL+"/loglevel", strings.NewReader(`{"level":"debug"}`))
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, zapcore.DebugLevel, logging.AtomicLevel().Level())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	assert.ErrorIs(t, <-errCh, http.ErrServerClosed)
+}
+
+func TestServiceAdminConfigDump(t *testing.T) {
+	s := &Service{
+		server: echo.New(),
+		cfg: &config.Config{
+			App: config.AppConfig{Name: "dump"},
+			Database: config.DatabaseConfig{
+				User:     "user",
+				Password: "db-password",
+			},
+			Admin: config.AdminConfig{Enabled: true, ConfigDump: true},
+		},
+		log: zap.NewNop(),
+	}
+	s.initAdminServer()
+
+	dump := func(query string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config"+query, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := dump("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"name": "dump"`)
+	assert.Contains(t, body, `"password": "[REDACTED]"`)
+	assert.NotContains(t, body, "db-password")
+
+	code, body = dump("?format=yaml")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "user: user")
+	assert.NotContains(t, body, "db-password")
+
+	code, _ = dump("?format=xml")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"sync"
 	"sync/atomic"
@@ -59,6 +60,85 @@ func TestServiceRunReturnsServerClosed(t *testing.T) {
 	}
 }
 
+func TestServiceRunContextDrainsGracefully(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	s := &Service{
+		server: echo.New(),
+		cfg: &config.Config{
+			HTTP: config.HttpConfig{
+				Address:         "127.0.0.1:0",
+				ShutdownDelay:   200 * time.Millisecond,
+				ShutdownTimeout: time.Second,
+			},
+			App: config.AppConfig{},
+		},
+		log: zap.NewNop(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.RunContext(ctx)
+	}()
+
+	// Allow server to start.
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, s.Ready())
+
+	cancel()
+	assert.Eventually(t, func() bool { return !s.Ready() }, time.Second, 10*time.Millisecond)
+
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for RunContext to return")
+	}
+}
+
+func TestServiceServesH2C(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	cfg := &config.Config{
+		HTTP: config.HttpConfig{
+			Address: "127.0.0.1:0",
+			H2C:     true,
+		},
+	}
+	cfg.SetDefaults()
+	s := &Service{cfg: cfg, log: zap.NewNop()}
+	s.initWebServer()
+	assert.Equal(t, config.DefaultHttpReadHeaderTimeout, s.server.Server.ReadHeaderTimeout)
+	assert.Equal(t, config.DefaultHttpMaxHeaderBytes, s.server.Server.MaxHeaderBytes)
+
+	s.GET("/proto", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Request().Proto)
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run()
+	}()
+	// Allow server to start.
+	time.Sleep(100 * time.Millisecond)
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	res, err := client.Get("http://" + s.server.ListenerAddr().String() + "/proto")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "HTTP/2.0", string(body))
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-errCh, http.ErrServerClosed)
+}
+
 func TestServiceRunWrapsStartupError(t *testing.T) {
 	logging.ResetForTests()
 	defer logging.ResetForTests()
@@ -105,6 +185,86 @@ func TestServiceShutdownClosesDatabase(t *testing.T) {
 	}, time.Second, 10*time.Millisecond)
 }
 
+func TestNewServiceWithOptions(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	db := &gorm.DB{
+		Config: &gorm.Config{
+			ConnPool: newTrackedSQLDB(t),
+		},
+	}
+	e := echo.New()
+	log := zap.NewNop()
+
+	s, err := NewService("does-not-exist.yml",
+		WithConfig(&config.Config{
+			App:  config.AppConfig{Name: "in-memory"},
+			HTTP: config.HttpConfig{Address: "127.0.0.1:0"},
+		}),
+		WithLogger(log),
+		WithDB(db),
+		WithEcho(e),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, "in-memory", s.Configuration().GetApp().Name)
+	assert.Equal(t, config.DefaultHttpReadTimeout, s.Configuration().GetHTTP().ReadTimeout)
+	assert.Same(t, log, s.Log())
+	assert.Same(t, db, s.Database())
+	assert.Same(t, e, s.server)
+
+	results := s.CheckHealth(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, "database", results[0].Name)
+	assert.Equal(t, "UP", results[0].Status)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&sqlCloseCount) > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNewServiceRejectsInvalidConfig(t *testing.T) {
+	_, err := NewService("",
+		WithConfig(&config.Config{
+			HTTP: config.HttpConfig{
+				Address:      "127.0.0.1:0",
+				WriteTimeout: -time.Second,
+				JwtEnabled:   true,
+			},
+		}),
+		WithLogger(zap.NewNop()),
+	)
+	require.Error(t, err)
+
+	var verrs config.ValidationErrors
+	require.ErrorAs(t, err, &verrs)
+	assert.Len(t, verrs, 2)
+	assert.Contains(t, err.Error(), "http.writeTimeout: must be at least 0")
+	assert.Contains(t, err.Error(), "http.jwtSigningKey: is required")
+}
+
+// strictConfig is a custom template with its own validation rules.
+type strictConfig struct {
+	config.Config
+}
+
+func (c *strictConfig) Validate() error {
+	if c.App.Name == config.DefaultAppName {
+		return errors.New("app.name: must be set")
+	}
+	return nil
+}
+
+func TestNewServiceUsesTemplateValidate(t *testing.T) {
+	_, err := NewService("",
+		WithConfig(&strictConfig{Config: config.Config{HTTP: config.HttpConfig{Address: "127.0.0.1:0"}}}),
+		WithLogger(zap.NewNop()),
+	)
+	assert.EqualError(t, err, "app.name: must be set")
+}
+
 func TestServiceInitDatabasePostgres(t *testing.T) {
 	logging.ResetForTests()
 	defer logging.ResetForTests()
@@ -141,8 +301,151 @@ func TestServiceInitDatabasePostgres(t *testing.T) {
 	assert.NotNil(t, s.db)
 }
 
+func TestServiceInitDatabaseRetries(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	var attempts int32
+	originalFactory := dialectorFactory
+	dialectorFactory = func(driver, dsn string) (gorm.Dialector, error) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return stubDialector{name: driver}, nil
+	}
+	t.Cleanup(func() { dialectorFactory = originalFactory })
+
+	newService := func(connect config.ConnectConfig) *Service {
+		atomic.StoreInt32(&attempts, 0)
+		return &Service{
+			cfg: &config.Config{
+				Database: config.DatabaseConfig{
+					Enabled: true,
+					Driver:  "postgres",
+					Connect: connect,
+				},
+			},
+			log: zap.NewNop(),
+		}
+	}
+
+	// not enough attempts
+	s := newService(config.ConnectConfig{Attempts: 2, InitialBackoff: time.Millisecond})
+	assert.ErrorContains(t, s.initDatabase(), "connection refused")
+	assert.Nil(t, s.Database())
+
+	// deadline exceeded before the last attempt
+	s = newService(config.ConnectConfig{Attempts: 3, InitialBackoff: time.Second, Timeout: 10 * time.Millisecond})
+	assert.ErrorContains(t, s.initDatabase(), "deadline exceeded")
+
+	s = newService(config.ConnectConfig{Attempts: 3, InitialBackoff: time.Millisecond})
+	assert.NoError(t, s.initDatabase())
+	assert.NotNil(t, s.Database())
+	assert.EqualValues(t, 3, atomic.LoadInt32(&attempts))
+
+	// lazy mode connects in the background
+	s = newService(config.ConnectConfig{Attempts: 3, InitialBackoff: 20 * time.Millisecond, Lazy: true})
+	assert.NoError(t, s.initDatabase())
+	assert.Nil(t, s.Database())
+	assert.Error(t, databaseModule{s}.Health(context.Background()))
+	assert.Eventually(t, func() bool { return s.Database() != nil }, time.Second, 10*time.Millisecond)
+}
+
+func TestServiceLazyConnectKeepsRetrying(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	var up atomic.Bool
+	var attempts int32
+	originalFactory := dialectorFactory
+	dialectorFactory = func(driver, dsn string) (gorm.Dialector, error) {
+		atomic.AddInt32(&attempts, 1)
+		if !up.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return stubDialector{name: driver}, nil
+	}
+	t.Cleanup(func() { dialectorFactory = originalFactory })
+
+	s := &Service{
+		cfg: &config.Config{
+			Database: config.DatabaseConfig{
+				Enabled: true,
+				Driver:  "postgres",
+				Connect: config.ConnectConfig{
+					Attempts:       2,
+					InitialBackoff: time.Millisecond,
+					MaxBackoff:     5 * time.Millisecond,
+					Lazy:           true,
+				},
+			},
+		},
+		log: zap.NewNop(),
+	}
+	require.NoError(t, s.initDatabase())
+	t.Cleanup(func() { _ = s.stopWorkers(context.Background()) })
+
+	// every attempt fails, and the worker is restarted instead of failing
+	assert.Eventually(t, func() bool {
+		workers := s.Workers()
+		return len(workers) == 1 && workers[0].Restarts >= 2
+	}, time.Second, time.Millisecond)
+	assert.Greater(t, atomic.LoadInt32(&attempts), int32(4))
+	assert.Nil(t, s.Database())
+	assert.NotEqual(t, WorkerFailed, s.Workers()[0].State)
+
+	// the database comes up later
+	up.Store(true)
+	assert.Eventually(t, func() bool { return s.Database() != nil }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return s.Workers()[0].State == WorkerStopped }, time.Second, time.Millisecond)
+}
+
+func TestServiceInitDatabaseTimeoutBoundsDial(t *testing.T) {
+	logging.ResetForTests()
+	defer logging.ResetForTests()
+
+	originalFactory := dialectorFactory
+	dialectorFactory = func(driver, dsn string) (gorm.Dialector, error) {
+		return stubDialector{name: driver, conn: sql.OpenDB(hangingConnector{})}, nil
+	}
+	t.Cleanup(func() { dialectorFactory = originalFactory })
+
+	s := &Service{
+		cfg: &config.Config{
+			Database: config.DatabaseConfig{
+				Enabled: true,
+				Driver:  "postgres",
+				Connect: config.ConnectConfig{Attempts: 3, InitialBackoff: time.Millisecond, Timeout: 50 * time.Millisecond},
+			},
+		},
+		log: zap.NewNop(),
+	}
+
+	start := time.Now()
+	err := s.initDatabase()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Nil(t, s.Database())
+}
+
+// hangingConnector dials until the context is done, like an unreachable
+// server dropping the packets.
+type hangingConnector struct{}
+
+func (hangingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(5 * time.Second):
+		return nil, errors.New("dial timeout")
+	}
+}
+
+func (hangingConnector) Driver() driver.Driver { return &trackingDriver{} }
+
 type stubDialector struct {
 	name string
+	conn gorm.ConnPool
 }
 
 func (d stubDialector) Name() string {
@@ -150,6 +453,9 @@ func (d stubDialector) Name() string {
 }
 
 func (d stubDialector) Initialize(db *gorm.DB) error {
+	if d.conn != nil {
+		db.ConnPool = d.conn
+	}
 	return nil
 }
 
@@ -12,6 +12,7 @@ import (
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
 	"github.com/rwbm/morondanga/common"
+	"github.com/rwbm/morondanga/config"
 	"github.com/rwbm/morondanga/middleware"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"go.opentelemetry.io/otel/trace"
@@ -91,7 +92,9 @@ func (s *Service) JwtToken(customClaims map[string]interface{}) string {
 }
 
 func (s *Service) initWebServer() {
-	s.server = echo.New()
+	if s.server == nil {
+		s.server = echo.New()
+	}
 
 	if s.Configuration().GetApp().LogLevel == int(zap.DebugLevel) {
 		s.server.Logger.SetLevel(1)
@@ -103,41 +106,35 @@ func (s *Service) initWebServer() {
 	s.server.Server.ReadTimeout = s.Configuration().GetHTTP().ReadTimeout
 	s.server.Server.WriteTimeout = s.Configuration().GetHTTP().WriteTimeout
 	s.server.Server.IdleTimeout = s.Configuration().GetHTTP().IdleTimeout
+	s.server.Server.ReadHeaderTimeout = s.Configuration().GetHTTP().ReadHeaderTimeout
+	s.server.Server.MaxHeaderBytes = s.Configuration().GetHTTP().MaxHeaderBytes
+	s.server.Server.SetKeepAlivesEnabled(!s.Configuration().GetHTTP().DisableKeepAlives)
+	if s.Configuration().GetHTTP().H2C {
+		s.server.Server.Protocols = h2cProtocols()
+	}
 
 	// middlewares
 	s.server.Pre(echoMiddleware.RemoveTrailingSlash())
 	s.server.Use(echoMiddleware.Recover())
 	// otelecho must be registered before the request logger so the span is
 	// already in the context when we log latency + status.
-	// Build the list of paths excluded from both OTEL tracing and request logging.
-	// Always includes /health (unless the service handles its own health check).
-	var excludedPaths []string
-	if obs := s.Configuration().GetObservability(); obs != nil {
-		excludedPaths = append(excludedPaths, obs.ExcludedPaths...)
-	}
-	if !s.Configuration().GetHTTP().CustomHealthCheck {
-		excludedPaths = append(excludedPaths, "/health")
-	}
+	// The paths excluded from both OTEL tracing and request logging, as well as
+	// the masked fields, are read on every request, since they can change when
+	// the configuration is reloaded.
+	s.web.Store(newWebSettings(s.Configuration()))
 
 	if obs := s.Configuration().GetObservability(); obs != nil && obs.Enabled {
-		var otelOpts []otelecho.Option
-		if len(excludedPaths) > 0 {
-			otelOpts = append(otelOpts, otelecho.WithSkipper(func(c echo.Context) bool {
-				path := c.Request().URL.Path
-				for _, p := range excludedPaths {
-					if strings.HasPrefix(path, p) {
-						return true
-					}
-				}
-				return false
-			}))
-		}
-		s.server.Use(otelecho.Middleware(s.Configuration().GetApp().Name, otelOpts...))
+		s.server.Use(otelecho.Middleware(s.Configuration().GetApp().Name, otelecho.WithSkipper(func(c echo.Context) bool {
+			return s.web.Load().excluded(c.Request().URL.Path)
+		})))
 	}
-	s.server.Use(s.httpRequestLogger(excludedPaths))
+	s.server.Use(s.httpRequestLogger())
 	if s.Configuration().GetHTTP().AddTraceID {
 		s.server.Use(middleware.Trace())
 	}
+	if tlsCfg := s.Configuration().GetHTTP().TLS; tlsCfg.Enabled && tlsCfg.ClientCAFile != "" {
+		s.server.Use(middleware.ClientCert())
+	}
 
 	// validator
 	s.server.Validator = newValidator()
@@ -147,26 +144,67 @@ func (s *Service) initWebServer() {
 		s.jwtHandler = middleware.Jwt([]byte(s.Configuration().GetHTTP().JwtSigningKey))
 	}
 
-	// healthcheck
-	if !s.Configuration().GetHTTP().CustomHealthCheck {
-		s.setHealthCheck()
+	// healthcheck; served by the admin server when it's enabled
+	if s.Configuration().GetAdmin().Enabled {
+		s.initAdminServer()
+	} else if !s.Configuration().GetHTTP().CustomHealthCheck {
+		s.setHealthCheck(s.server)
+	}
+}
+
+// h2cProtocols returns the protocols served when cleartext HTTP/2 is enabled.
+// HTTP/2 over TLS is kept, for when TLS is enabled too.
+func h2cProtocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+}
+
+// webSettings are the HTTP settings that apply live when the configuration
+// is reloaded.
+type webSettings struct {
+	excludedPaths    []string
+	maskedBodyFields []string
+	maskedHeaders    []string
+}
+
+func newWebSettings(cfg config.ConfigTemplate) *webSettings {
+	w := &webSettings{
+		maskedBodyFields: cfg.GetHTTP().MaskedBodyFields,
+		maskedHeaders:    cfg.GetHTTP().MaskedHeaders,
 	}
+	if obs := cfg.GetObservability(); obs != nil {
+		w.excludedPaths = append(w.excludedPaths, obs.ExcludedPaths...)
+	}
+	// always exclude the health endpoints, unless the service handles its own health check
+	if !cfg.GetHTTP().CustomHealthCheck {
+		w.excludedPaths = append(w.excludedPaths, "/health", "/livez", "/readyz")
+	}
+	return w
 }
 
-func (s *Service) httpRequestLogger(excluded []string) echo.MiddlewareFunc {
-	httpCfg := s.Configuration().GetHTTP()
-	maskedBodyFields := httpCfg.MaskedBodyFields
-	maskedHeaders := httpCfg.MaskedHeaders
+// excluded tells if path is excluded from tracing and request logging.
+func (w *webSettings) excluded(path string) bool {
+	for _, p := range w.excludedPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
 
+func (s *Service) httpRequestLogger() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			req := c.Request()
-			path := req.URL.Path
-			for _, p := range excluded {
-				if strings.HasPrefix(path, p) {
-					return next(c)
-				}
+			web := s.web.Load()
+			if web.excluded(req.URL.Path) {
+				return next(c)
 			}
+			maskedBodyFields := web.maskedBodyFields
+			maskedHeaders := web.maskedHeaders
 
 			inFields := []zap.Field{
 				zap.String("method", req.Method),
@@ -229,7 +267,7 @@ func (s *Service) httpRequestLogger(excluded []string) echo.MiddlewareFunc {
 func flatHeaders(h http.Header, extra []string) map[string]string {
 	always := map[string]struct{}{
 		"authorization": {},
-		"x-api-key":    {},
+		"x-api-key":     {},
 	}
 	for _, k := range extra {
 		always[strings.ToLower(k)] = struct{}{}
@@ -317,15 +355,3 @@ func (rc *responseCapture) Flush() {
 func (rc *responseCapture) bytes() []byte {
 	return rc.buf.Bytes()
 }
-
-func (s *Service) setHealthCheck() {
-	// very basic health check;
-	// we have some ideas to improve this with some custom checkers
-	s.server.GET("/health", func(c echo.Context) error {
-		type healthResponse struct {
-			Status string
-		}
-		resp := healthResponse{Status: "OK"}
-		return c.JSON(http.StatusOK, resp)
-	})
-}
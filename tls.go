@@ -0,0 +1,211 @@
+package morondanga
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rwbm/morondanga/config"
+	"go.uber.org/zap"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig builds the server TLS configuration. Certificates and client CAs
+// are served through a certReloader, so they're reloaded when the files change.
+func (s *Service) newTLSConfig(tlsCfg config.TLSConfig) (*tls.Config, error) {
+	if tlsCfg.CertFile == "" || tlsCfg.KeyFile == "" {
+		return nil, errors.New("tls: certFile and keyFile are required")
+	}
+
+	minVersion := tls.VersionTLS12
+	if tlsCfg.MinVersion != "" {
+		v, ok := tlsVersions[tlsCfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("tls: unsupported min version %q", tlsCfg.MinVersion)
+		}
+		minVersion = int(v)
+	}
+
+	cipherSuites, err := tlsCipherSuites(tlsCfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	reloader := &certReloader{
+		certFile: tlsCfg.CertFile,
+		keyFile:  tlsCfg.KeyFile,
+		caFile:   tlsCfg.ClientCAFile,
+		interval: tlsCfg.ReloadInterval,
+		log:      s.Log(),
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{
+		MinVersion:     uint16(minVersion),
+		CipherSuites:   cipherSuites,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if tlsCfg.ClientCAFile != "" {
+		switch strings.ToLower(tlsCfg.ClientAuth) {
+		case "request":
+			base.ClientAuth = tls.VerifyClientCertIfGiven
+		case "require", "":
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		default:
+			return nil, fmt.Errorf("tls: unsupported client auth mode %q", tlsCfg.ClientAuth)
+		}
+		base.ClientCAs = reloader.clientCAs
+		// hand out a config with the current client CAs on every handshake
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			reloader.maybeReload()
+			cfg := base.Clone()
+			cfg.GetConfigForClient = nil
+			cfg.ClientCAs = reloader.currentClientCAs()
+			return cfg, nil
+		}
+	}
+
+	return base, nil
+}
+
+func tlsCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// certReloader keeps the certificate and client CAs loaded from disk, and
+// reloads them when the files change, checking at most once per interval.
+// When reloading fails, the previous certificate is kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+	log      *zap.Logger
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  [3]time.Time
+	checkedAt time.Time
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.maybeReload()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) currentClientCAs() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.clientCAs
+}
+
+func (r *certReloader) maybeReload() {
+	r.mu.Lock()
+	if time.Since(r.checkedAt) < r.interval {
+		r.mu.Unlock()
+		return
+	}
+	r.checkedAt = time.Now()
+	modTimes, err := r.currentModTimes()
+	changed := err == nil && modTimes != r.modTimes
+	r.mu.Unlock()
+
+	if err != nil {
+		r.log.Warn("Failed to check TLS certificate files", zap.Error(err))
+		return
+	}
+	if !changed {
+		return
+	}
+	if err := r.load(); err != nil {
+		r.log.Error("Failed to reload TLS certificate; keeping the previous one", zap.Error(err))
+		return
+	}
+	r.log.Info("TLS certificate reloaded", zap.String("certFile", r.certFile))
+}
+
+// load reads the files and replaces the current certificate and client CAs.
+func (r *certReloader) load() error {
+	modTimes, err := r.currentModTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: load key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("tls: read client CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificates found in client CA file %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.modTimes = modTimes
+	r.checkedAt = time.Now()
+	return nil
+}
+
+func (r *certReloader) currentModTimes() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			return modTimes, fmt.Errorf("tls: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
@@ -0,0 +1,170 @@
+package morondanga
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rwbm/morondanga/config"
+	"github.com/rwbm/morondanga/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM encoded certificate and key signed by the CA.
+func (ca *testCA) issue(t *testing.T, serial int64, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"acme"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestServiceMutualTLSWithReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	writeServerCert := func(serial int64) {
+		certPEM, keyPEM := ca.issue(t, serial, "server", x509.ExtKeyUsageServerAuth)
+		require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+		require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+		// make sure the modification time changes on coarse filesystems
+		mod := time.Now().Add(time.Duration(serial) * time.Second)
+		require.NoError(t, os.Chtimes(certFile, mod, mod))
+	}
+	writeServerCert(10)
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+
+	s := &Service{
+		server: echo.New(),
+		cfg: &config.Config{
+			HTTP: config.HttpConfig{
+				Address: "127.0.0.1:0",
+				TLS: config.TLSConfig{
+					Enabled:        true,
+					CertFile:       certFile,
+					KeyFile:        keyFile,
+					MinVersion:     "1.2",
+					ClientCAFile:   caFile,
+					ClientAuth:     "require",
+					ReloadInterval: time.Millisecond,
+				},
+			},
+		},
+		log: zap.NewNop(),
+	}
+	s.server.Use(middleware.ClientCert())
+	s.GET("/whoami", func(c echo.Context) error {
+		subject, _ := middleware.ClientCertSubject(c)
+		return c.String(http.StatusOK, subject)
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run()
+	}()
+	// Allow server to start.
+	time.Sleep(100 * time.Millisecond)
+	url := "https://" + s.server.TLSListenerAddr().String() + "/whoami"
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCertPEM, clientKeyPEM := ca.issue(t, 20, "orders", x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			DisableKeepAlives: true,
+		}}
+	}
+
+	// clients without certificate are rejected
+	_, err = newClient().Get(url)
+	assert.Error(t, err)
+
+	res, err := newClient(clientCert).Get(url)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "CN=orders,O=acme", string(body))
+	assert.EqualValues(t, 10, res.TLS.PeerCertificates[0].SerialNumber.Int64())
+
+	// rotated certificates are served without restarting
+	writeServerCert(11)
+	time.Sleep(5 * time.Millisecond)
+	res, err = newClient(clientCert).Get(url)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.EqualValues(t, 11, res.TLS.PeerCertificates[0].SerialNumber.Int64())
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-errCh, http.ErrServerClosed)
+}
+
+func TestTLSCipherSuites(t *testing.T) {
+	ids, err := tlsCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, ids)
+
+	_, err = tlsCipherSuites([]string{"TLS_NOT_A_SUITE"})
+	assert.Error(t, err)
+}
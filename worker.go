@@ -0,0 +1,271 @@
+package morondanga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var (
+	// DefaultWorkerInitialBackoff is the delay before the first restart of a failed worker.
+	DefaultWorkerInitialBackoff = time.Second
+	// DefaultWorkerMaxBackoff caps the delay between restarts of a failed worker.
+	DefaultWorkerMaxBackoff = 30 * time.Second
+)
+
+// RestartPolicy defines what happens when a worker function returns an error or panics.
+type RestartPolicy int
+
+const (
+	// RestartNever leaves the worker in failed state.
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure restarts the worker with exponential backoff.
+	RestartOnFailure
+)
+
+// WorkerState is the current state of a background worker.
+type WorkerState string
+
+const (
+	WorkerRunning    WorkerState = "running"
+	WorkerRestarting WorkerState = "restarting"
+	WorkerStopped    WorkerState = "stopped"
+	WorkerFailed     WorkerState = "failed"
+)
+
+// WorkerStatus is a snapshot of a background worker, as reported by the health check.
+type WorkerStatus struct {
+	Name      string
+	State     WorkerState
+	Restarts  int
+	LastError string `json:",omitempty"`
+	StartedAt time.Time
+}
+
+// WorkerOption configures a background worker started with Service.Go.
+type WorkerOption func(w *worker)
+
+// WithRestartPolicy sets the worker restart policy. Default is RestartNever.
+func WithRestartPolicy(p RestartPolicy) WorkerOption {
+	return func(w *worker) {
+		w.policy = p
+	}
+}
+
+// WithRestartBackoff sets the initial and maximum delay between restarts,
+// when the restart policy is RestartOnFailure. A max <= 0 means no maximum.
+func WithRestartBackoff(initial, max time.Duration) WorkerOption {
+	return func(w *worker) {
+		w.initialBackoff = initial
+		w.maxBackoff = max
+	}
+}
+
+type worker struct {
+	name           string
+	fn             func(ctx context.Context) error
+	policy         RestartPolicy
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu     sync.Mutex
+	status WorkerStatus
+}
+
+func (w *worker) setState(state WorkerState, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.State = state
+	if err != nil {
+		w.status.LastError = err.Error()
+	}
+	if state == WorkerRunning {
+		w.status.StartedAt = time.Now()
+	}
+}
+
+func (w *worker) snapshot() WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+type workerMetrics struct {
+	runs     metric.Int64Counter
+	failures metric.Int64Counter
+	panics   metric.Int64Counter
+	restarts metric.Int64Counter
+}
+
+// Go runs fn in a background goroutine supervised by the service. The context
+// passed to fn is cancelled during Shutdown, which waits for the worker to return.
+//
+// Panics are recovered and logged, and handled like errors by the restart policy.
+// Each run of fn is traced in its own span.
+func (s *Service) Go(name string, fn func(ctx context.Context) error, opts ...WorkerOption) {
+	w := &worker{
+		name:           name,
+		fn:             fn,
+		policy:         RestartNever,
+		initialBackoff: DefaultWorkerInitialBackoff,
+		maxBackoff:     DefaultWorkerMaxBackoff,
+		status:         WorkerStatus{Name: name},
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	s.workersMu.Lock()
+	if s.workersCtx == nil {
+		s.workersCtx, s.workersCancel = context.WithCancel(context.Background())
+	}
+	ctx := s.workersCtx
+	s.workers = append(s.workers, w)
+	s.workersWG.Add(1)
+	s.workersMu.Unlock()
+
+	go s.superviseWorker(ctx, w)
+}
+
+// Workers returns the status of the background workers started with Go.
+func (s *Service) Workers() []WorkerStatus {
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+
+	statuses := make([]WorkerStatus, 0, len(s.workers))
+	for _, w := range s.workers {
+		statuses = append(statuses, w.snapshot())
+	}
+	return statuses
+}
+
+// stopWorkers cancels all the workers and waits for them to return, or for ctx to be done.
+func (s *Service) stopWorkers(ctx context.Context) error {
+	s.workersMu.Lock()
+	cancel := s.workersCancel
+	s.workersMu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.workersWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for workers: %w", ctx.Err())
+	}
+}
+
+func (s *Service) superviseWorker(ctx context.Context, w *worker) {
+	defer s.workersWG.Done()
+
+	log := s.Log().With(zap.String("worker", w.name))
+	for attempt := 0; ; {
+		w.setState(WorkerRunning, nil)
+		started := time.Now()
+		err := s.runWorker(ctx, w)
+
+		if ctx.Err() != nil {
+			w.setState(WorkerStopped, nil)
+			return
+		}
+		if err == nil {
+			log.Info("Worker finished")
+			w.setState(WorkerStopped, nil)
+			return
+		}
+		if w.policy == RestartNever {
+			log.Error("Worker failed", zap.Error(err))
+			w.setState(WorkerFailed, err)
+			return
+		}
+
+		// a worker that ran for a while before failing starts backing off from
+		// scratch; without a maximum backoff the delay keeps growing instead
+		if w.maxBackoff > 0 && time.Since(started) > w.maxBackoff {
+			attempt = 0
+		}
+		delay := backoffDelay(attempt, w.initialBackoff, w.maxBackoff)
+		attempt++
+
+		log.Error("Worker failed; restarting", zap.Error(err), zap.Duration("backoff", delay))
+		w.setState(WorkerRestarting, err)
+
+		select {
+		case <-ctx.Done():
+			w.setState(WorkerStopped, nil)
+			return
+		case <-time.After(delay):
+		}
+
+		w.mu.Lock()
+		w.status.Restarts++
+		w.mu.Unlock()
+		s.workerMetrics().restarts.Add(ctx, 1, metric.WithAttributes(attribute.String("worker.name", w.name)))
+	}
+}
+
+// runWorker runs the worker function once, in its own span, turning panics into errors.
+func (s *Service) runWorker(ctx context.Context, w *worker) (err error) {
+	attrs := metric.WithAttributes(attribute.String("worker.name", w.name))
+	metrics := s.workerMetrics()
+
+	ctx, span := s.workerTracer().Start(ctx, "worker "+w.name)
+	defer span.End()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("worker panic: %v", r)
+			s.Log().Error("Worker panicked", zap.String("worker", w.name), zap.Any("panic", r), zap.Stack("stack"))
+			metrics.panics.Add(ctx, 1, attrs)
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			metrics.failures.Add(ctx, 1, attrs)
+		}
+	}()
+
+	metrics.runs.Add(ctx, 1, attrs)
+	return w.fn(ctx)
+}
+
+func (s *Service) workerTracer() trace.Tracer {
+	if s.tracer != nil {
+		return s.tracer
+	}
+	return otel.Tracer("")
+}
+
+func (s *Service) workerMetrics() *workerMetrics {
+	s.workerMetricsOnce.Do(func() {
+		meter := otel.Meter(s.Configuration().GetApp().Name)
+		m := &workerMetrics{}
+		m.runs, _ = meter.Int64Counter("worker.runs",
+			metric.WithDescription("Number of times a background worker function was started"))
+		m.failures, _ = meter.Int64Counter("worker.failures",
+			metric.WithDescription("Number of times a background worker function failed or panicked"))
+		m.panics, _ = meter.Int64Counter("worker.panics",
+			metric.WithDescription("Number of times a background worker function panicked"))
+		m.restarts, _ = meter.Int64Counter("worker.restarts",
+			metric.WithDescription("Number of times a background worker was restarted"))
+		s.workerMeters = m
+	})
+	return s.workerMeters
+}
@@ -0,0 +1,129 @@
+package morondanga
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rwbm/morondanga/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newWorkerTestService() *Service {
+	return &Service{
+		cfg: &config.Config{},
+		log: zap.NewNop(),
+	}
+}
+
+func TestServiceGoRestartsOnFailureAndRecoversPanics(t *testing.T) {
+	s := newWorkerTestService()
+
+	var runs int32
+	s.Go("flaky", func(ctx context.Context) error {
+		switch atomic.AddInt32(&runs, 1) {
+		case 1:
+			return errors.New("transient")
+		case 2:
+			panic("unexpected")
+		default:
+			<-ctx.Done()
+			return ctx.Err()
+		}
+	}, WithRestartPolicy(RestartOnFailure), WithRestartBackoff(time.Millisecond, 5*time.Millisecond))
+
+	assert.Eventually(t, func() bool {
+		st := s.Workers()[0]
+		return st.State == WorkerRunning && st.Restarts == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Contains(t, s.Workers()[0].LastError, "worker panic: unexpected")
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, WorkerStopped, s.Workers()[0].State)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&runs))
+}
+
+func TestServiceGoBackoffWithoutMax(t *testing.T) {
+	s := newWorkerTestService()
+
+	var (
+		mu   sync.Mutex
+		runs []time.Time
+	)
+	s.Go("failing", func(ctx context.Context) error {
+		mu.Lock()
+		runs = append(runs, time.Now())
+		n := len(runs)
+		mu.Unlock()
+		if n > 8 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return errors.New("transient")
+	}, WithRestartPolicy(RestartOnFailure), WithRestartBackoff(time.Millisecond, 0))
+
+	assert.Eventually(t, func() bool {
+		return s.Workers()[0].Restarts == 8
+	}, 2*time.Second, 5*time.Millisecond)
+	require.NoError(t, s.Shutdown(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the 8th restart waits at least half of 1ms << 7
+	assert.GreaterOrEqual(t, runs[8].Sub(runs[7]), 64*time.Millisecond)
+}
+
+func TestServiceGoNeverRestart(t *testing.T) {
+	s := newWorkerTestService()
+
+	s.Go("once", func(ctx context.Context) error {
+		return errors.New("fatal")
+	})
+
+	assert.Eventually(t, func() bool {
+		return s.Workers()[0].State == WorkerFailed
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, s.Workers()[0].Restarts)
+	assert.Equal(t, "fatal", s.Workers()[0].LastError)
+}
+
+func TestServiceShutdownTimesOutOnStuckWorker(t *testing.T) {
+	s := newWorkerTestService()
+
+	s.Go("stuck", func(ctx context.Context) error {
+		<-make(chan struct{})
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoffDelay(attempt, 100*time.Millisecond, time.Second)
+		want := 100 * time.Millisecond << attempt
+		if want > time.Second {
+			want = time.Second
+		}
+		assert.GreaterOrEqual(t, d, want/2)
+		assert.LessOrEqual(t, d, want)
+	}
+
+	// no cap
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoffDelay(attempt, 100*time.Millisecond, 0)
+		want := 100 * time.Millisecond << attempt
+		assert.GreaterOrEqual(t, d, want/2)
+		assert.LessOrEqual(t, d, want)
+	}
+	assert.Positive(t, backoffDelay(100, time.Second, 0), "doesn't overflow")
+}